@@ -8,14 +8,25 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/mattmattox/kubebackup/pkg/backup"
+	"github.com/mattmattox/kubebackup/pkg/compression"
 	"github.com/mattmattox/kubebackup/pkg/config"
+	"github.com/mattmattox/kubebackup/pkg/encryption"
 	"github.com/mattmattox/kubebackup/pkg/k8s"
+	"github.com/mattmattox/kubebackup/pkg/local"
 	"github.com/mattmattox/kubebackup/pkg/logging"
+	"github.com/mattmattox/kubebackup/pkg/manifest"
+	"github.com/mattmattox/kubebackup/pkg/redact"
+	"github.com/mattmattox/kubebackup/pkg/restore"
+	"github.com/mattmattox/kubebackup/pkg/s3"
+	"github.com/mattmattox/kubebackup/pkg/signing"
+	"github.com/mattmattox/kubebackup/pkg/storage"
+	"github.com/mattmattox/kubebackup/pkg/verify"
 	"github.com/mattmattox/kubebackup/pkg/version"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -24,29 +35,52 @@ import (
 	"k8s.io/client-go/kubernetes"
 )
 
+// backupInfo is the status of the last backup reported by /status.
+type backupInfo struct {
+	Status  string                 `json:"status"`
+	Message string                 `json:"message"`
+	Time    string                 `json:"time"`
+	Targets []storage.UploadResult `json:"targets,omitempty"`
+
+	// Counts of objects written and failed, and the resources and objects that could not be backed up
+	ObjectsWritten int                `json:"objectsWritten"`
+	ObjectsFailed  int                `json:"objectsFailed"`
+	Failures       []manifest.Failure `json:"failures,omitempty"`
+
+	// Verification holds the results of the last verification of stored backups
+	Verification []*verify.Result `json:"verification,omitempty"`
+}
+
 var (
-	logger         = logging.SetupLogging()
-	taskLock       sync.Mutex
-	isTaskRunning  bool
-	lastBackupInfo = struct {
-		Status  string `json:"status"`
-		Message string `json:"message"`
-		Time    string `json:"time"`
-	}{
+	logger        = logging.SetupLogging()
+	taskLock      sync.Mutex
+	isTaskRunning bool
+	apiTasks      sync.WaitGroup
+	// lastBackupInfoLock guards lastBackupInfo, which is updated by backups and verifications and read by /status.
+	lastBackupInfoLock sync.Mutex
+	lastBackupInfo     = backupInfo{
 		Status:  "unknown",
 		Message: "No backups have been run yet.",
 		Time:    "",
 	}
 
 	// Prometheus Metrics
-	lastBackupStatus   = prometheus.NewGauge(prometheus.GaugeOpts{Name: "last_backup_status", Help: "The status of the last backup: 1 for success, 0 for failure."})
-	lastBackupTime     = prometheus.NewGauge(prometheus.GaugeOpts{Name: "last_backup_timestamp", Help: "Last successful backup timestamp."})
-	lastBackupDuration = prometheus.NewGauge(prometheus.GaugeOpts{Name: "last_backup_duration_seconds", Help: "Duration of the last backup in seconds."})
+	lastBackupStatus       = prometheus.NewGauge(prometheus.GaugeOpts{Name: "last_backup_status", Help: "The status of the last backup: 1 for success, 0 for failure."})
+	lastBackupTime         = prometheus.NewGauge(prometheus.GaugeOpts{Name: "last_backup_timestamp", Help: "Last successful backup timestamp."})
+	lastBackupDuration     = prometheus.NewGauge(prometheus.GaugeOpts{Name: "last_backup_duration_seconds", Help: "Duration of the last backup in seconds."})
+	lastBackupPartial      = prometheus.NewGauge(prometheus.GaugeOpts{Name: "last_backup_partial", Help: "Whether the last backup is missing resources or objects that could not be backed up: 1 for partial, 0 otherwise."})
+	lastBackupObjects      = prometheus.NewGauge(prometheus.GaugeOpts{Name: "last_backup_objects_written", Help: "Number of objects written by the last backup."})
+	lastBackupObjectErrors = prometheus.NewGauge(prometheus.GaugeOpts{Name: "last_backup_objects_failed", Help: "Number of objects the last backup failed to write."})
+	lastBackupFailures     = prometheus.NewGauge(prometheus.GaugeOpts{Name: "last_backup_failures", Help: "Number of resources and objects the last backup could not back up."})
+	lastBackupTargetStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "last_backup_target_status", Help: "The upload status of the last backup per target: 1 for success, 0 for failure."}, []string{"target"})
+	lastRetentionStatus    = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "last_retention_status", Help: "The result of the retention cleanup after the last backup per target: 1 for success, 0 for failure."}, []string{"target"})
+	lastVerifyStatus       = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "last_verify_status", Help: "The result of the last verification per target: 1 for a valid backup, 0 for an invalid one."}, []string{"target"})
+	lastVerifyTime         = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "last_verify_timestamp", Help: "Timestamp of the last verification per target."}, []string{"target"})
 )
 
 func init() {
 	// Register Prometheus Metrics
-	prometheus.MustRegister(lastBackupStatus, lastBackupTime, lastBackupDuration)
+	prometheus.MustRegister(lastBackupStatus, lastBackupTime, lastBackupDuration, lastBackupPartial, lastBackupObjects, lastBackupObjectErrors, lastBackupFailures, lastBackupTargetStatus, lastRetentionStatus, lastVerifyStatus, lastVerifyTime)
 }
 
 func main() {
@@ -55,6 +89,17 @@ func main() {
 	// Load configuration from environment variables
 	config.LoadConfiguration()
 
+	// Allow the mode to be passed as the first argument, e.g. "kubebackup restore --dry-run"
+	if flag.NArg() > 0 {
+		config.CFG.Mode = flag.Arg(0)
+		switch config.CFG.Mode {
+		case "restore":
+			parseRestoreFlags(flag.Args()[1:])
+		case "verify":
+			parseVerifyFlags(flag.Args()[1:])
+		}
+	}
+
 	logging.SetupLogging()
 
 	// Validate configuration
@@ -62,34 +107,77 @@ func main() {
 		logger.Fatalf("Configuration validation failed: %v", err)
 	}
 
+	// Context canceled by shutdown signals
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Handle verify mode, which only needs access to the targets
+	if config.CFG.Mode == "verify" {
+		targets, err := createTargets(config.CFG.Targets)
+		if err != nil {
+			logger.Fatalf("Error creating backup targets: %v", err)
+		}
+		targets, err = selectTargets(targets, config.CFG.VerifyTarget)
+		if err != nil {
+			logger.Fatalf("Error selecting target: %v", err)
+		}
+		results := performVerify(ctx, targets, nil)
+		json.NewEncoder(os.Stdout).Encode(results)
+		for _, result := range results {
+			if !result.Valid {
+				logger.Fatalf("Verification failed for backup %s in target %s", result.Archive, result.Target)
+			}
+		}
+		logger.Println("Verification completed successfully. Exiting...")
+		return
+	}
+
 	// Connect to the Kubernetes cluster
-	clientset, dynamicClient, err := k8s.ConnectToCluster(config.CFG.Kubeconfig)
+	clientset, dynamicClient, err := k8s.ConnectToCluster(config.CFG.Kubeconfig, config.CFG.KubeAPIQPS, config.CFG.KubeAPIBurst)
 	if err != nil {
 		logger.Fatalf("Error creating clientset: %v", err)
 	}
 
 	// Verify access to the cluster
-	err = k8s.VerifyAccessToCluster(clientset)
+	err = k8s.VerifyAccessToCluster(ctx, clientset)
 	if err != nil {
 		logger.Fatalf("Error verifying access to cluster: %v", err)
 	}
 
-	// Start HTTP server for admin and metrics
-	logger.Println("Starting HTTP server for metrics and admin endpoints...")
-	server := startHTTPServer(clientset, dynamicClient)
+	// Handle restore mode
+	if config.CFG.Mode == "restore" {
+		logger.Println("Restore mode is enabled. Restoring backup and exiting.")
+		if err := restore.StartRestore(ctx, clientset, dynamicClient, &config.CFG); err != nil {
+			logger.Fatalf("Restore failed: %v", err)
+		}
+		logger.Println("Restore completed successfully. Exiting...")
+		return
+	}
+
+	// Create the storage targets backups are uploaded to
+	targets, err := createTargets(config.CFG.Targets)
+	if err != nil {
+		logger.Fatalf("Error creating backup targets: %v", err)
+	}
+	logResourceFilters()
 
-	// Context to handle shutdown signals
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Tasks run with their own context so a shutdown signal lets a running backup finish. It is only canceled,
+	// aborting the backup cleanly, if the backup is still running once the shutdown timeout has passed.
+	runCtx, cancelRuns := context.WithCancel(context.Background())
+	defer cancelRuns()
+	go func() {
+		<-ctx.Done()
+		time.AfterFunc(config.CFG.ShutdownTimeout, cancelRuns)
+	}()
 
-	// Listen for OS signals
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+	// Start HTTP server for admin and metrics
+	logger.Println("Starting HTTP server for metrics and admin endpoints...")
+	server := startHTTPServer(runCtx, clientset, dynamicClient, targets)
 
 	// Handle RunOnce flag
 	if config.CFG.RunOnce {
 		logger.Println("RunOnce flag is enabled. Performing a single backup and exiting.")
-		performBackup(clientset, dynamicClient)
+		performBackup(runCtx, clientset, dynamicClient, targets)
 		logger.Println("Task execution completed. Exiting...")
 		if err := server.Shutdown(context.Background()); err != nil {
 			logger.Printf("Error during server shutdown: %v", err)
@@ -107,7 +195,7 @@ func main() {
 	c := cron.New()
 	_, err = c.AddFunc(config.CFG.CronSchedule, func() {
 		logger.Println("Starting scheduled backup...")
-		performBackup(clientset, dynamicClient)
+		performBackup(runCtx, clientset, dynamicClient, targets)
 	})
 	if err != nil {
 		logger.Fatalf("Error adding cron job: %v", err)
@@ -116,89 +204,330 @@ func main() {
 	logger.Println("Starting cron scheduler...")
 	c.Start()
 
-	// Wait for termination signals
-	go func() {
-		<-signalChan
-		logger.Println("Received shutdown signal, stopping cron scheduler...")
-		c.Stop()
-		cancel()
-	}()
-
-	// Wait for the context to be canceled
+	// Wait for a termination signal
 	<-ctx.Done()
+	logger.Println("Received shutdown signal, stopping cron scheduler...")
+	cronDone := c.Stop()
+
+	// Stop accepting API requests, then wait for running tasks
+	if err := server.Shutdown(context.Background()); err != nil {
+		logger.Printf("Error during server shutdown: %v", err)
+	}
+	logger.Printf("Waiting up to %v for running tasks to finish...", config.CFG.ShutdownTimeout)
+	<-cronDone.Done()
+	apiTasks.Wait()
 
 	logger.Println("Exiting gracefully.")
 }
 
+// parseRestoreFlags parses the flags that follow the restore command and overrides the matching configuration.
+func parseRestoreFlags(args []string) {
+	restoreFlags := flag.NewFlagSet("restore", flag.ExitOnError)
+	restoreFlags.StringVar(&config.CFG.RestoreFile, "file", config.CFG.RestoreFile, "Path to the backup archive to restore")
+	restoreFlags.StringVar(&config.CFG.RestoreSignatureFile, "signature", config.CFG.RestoreSignatureFile, "Path to the detached signature of the archive (defaults to <file>.sig)")
+	restoreFlags.BoolVar(&config.CFG.RestoreDryRun, "dry-run", config.CFG.RestoreDryRun, "Validate the restore with a server-side dry run without changing the cluster")
+	if err := restoreFlags.Parse(args); err != nil {
+		logger.Fatalf("Error parsing restore flags: %v", err)
+	}
+}
+
+// parseVerifyFlags parses the flags that follow the verify command and overrides the matching configuration.
+func parseVerifyFlags(args []string) {
+	verifyFlags := flag.NewFlagSet("verify", flag.ExitOnError)
+	verifyFlags.StringVar(&config.CFG.VerifyTarget, "target", config.CFG.VerifyTarget, "Name of the target to verify (defaults to every target)")
+	verifyFlags.StringVar(&config.CFG.VerifyArchive, "archive", config.CFG.VerifyArchive, "Key of the archive to verify (defaults to the most recent backup)")
+	if err := verifyFlags.Parse(args); err != nil {
+		logger.Fatalf("Error parsing verify flags: %v", err)
+	}
+}
+
 // validateConfig ensures required fields are set in the configuration.
 func validateConfig() error {
+	switch config.CFG.Mode {
+	case "backup", "verify":
+	case "restore":
+		if config.CFG.RestoreFile == "" {
+			return fmt.Errorf("RestoreFile must be set in restore mode")
+		}
+		if err := restore.ValidateConflictPolicy(config.CFG.RestoreConflictPolicy); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid mode: %s", config.CFG.Mode)
+	}
 	if config.CFG.CronSchedule == "" {
 		return fmt.Errorf("CronSchedule cannot be empty")
 	}
-	if config.CFG.BackupTarget == "s3" {
-		if config.CFG.S3AccessKeyID == "" || config.CFG.S3SecretAccessKey == "" {
-			return fmt.Errorf("S3 configuration is incomplete: missing AccessKeyID or SecretAccessKey")
+	switch config.CFG.OutputFormat {
+	case "yaml", "json", "both":
+	default:
+		return fmt.Errorf("invalid output format: %s", config.CFG.OutputFormat)
+	}
+	switch config.CFG.BackupStrategy {
+	case "namespace", "cluster":
+	default:
+		return fmt.Errorf("invalid backup strategy: %s", config.CFG.BackupStrategy)
+	}
+	if config.CFG.ListPageSize < 0 {
+		return fmt.Errorf("ListPageSize cannot be negative")
+	}
+	if config.CFG.Workers < 1 {
+		return fmt.Errorf("Workers must be at least 1")
+	}
+	if config.CFG.KubeAPIQPS < 1 || config.CFG.KubeAPIBurst < 1 {
+		return fmt.Errorf("KubeAPIQPS and KubeAPIBurst must be at least 1")
+	}
+	if config.CFG.BackupTimeout < 0 || config.CFG.ShutdownTimeout < 0 {
+		return fmt.Errorf("BackupTimeout and ShutdownTimeout cannot be negative")
+	}
+	if err := compression.Validate(config.CFG.Compression, config.CFG.CompressionLevel); err != nil {
+		return err
+	}
+	if err := encryption.Validate(&config.CFG); err != nil {
+		return err
+	}
+	if _, err := redact.NewRedactor(&config.CFG); err != nil {
+		return err
+	}
+	if err := signing.Validate(&config.CFG); err != nil {
+		return err
+	}
+	if err := backup.ValidateFilters(&config.CFG); err != nil {
+		return err
+	}
+	if config.CFG.Mode != "restore" {
+		if config.CFG.TargetsErr != nil {
+			return config.CFG.TargetsErr
+		}
+		if err := validateTargets(config.CFG.Targets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTargets ensures at least one target is configured and each target is complete.
+func validateTargets(targets []config.TargetConfig) error {
+	if len(targets) == 0 {
+		return fmt.Errorf("no backup targets configured")
+	}
+	names := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if names[target.Name] {
+			return fmt.Errorf("duplicate target name: %s", target.Name)
 		}
-		if config.CFG.S3Bucket == "" {
-			return fmt.Errorf("S3 configuration is incomplete: missing Bucket")
+		names[target.Name] = true
+
+		switch target.Type {
+		case "s3":
+			if target.S3AccessKeyID == "" || target.S3SecretAccessKey == "" {
+				return fmt.Errorf("S3 configuration for target %s is incomplete: missing AccessKeyID or SecretAccessKey", target.Name)
+			}
+			if target.S3Bucket == "" {
+				return fmt.Errorf("S3 configuration for target %s is incomplete: missing Bucket", target.Name)
+			}
+		case "local":
+			if target.Dir == "" {
+				return fmt.Errorf("local configuration for target %s is incomplete: missing Dir", target.Name)
+			}
+		default:
+			return fmt.Errorf("invalid type for target %s: %s", target.Name, target.Type)
 		}
 	}
 	return nil
 }
 
 // performBackup triggers the backup process and updates metrics/status.
-func performBackup(clientset *kubernetes.Clientset, dynamicClient dynamic.Interface) {
+func performBackup(ctx context.Context, clientset *kubernetes.Clientset, dynamicClient dynamic.Interface, targets []storage.Target) {
 	startTime := time.Now()
 
-	status, err := backup.StartBackup(clientset, dynamicClient, &config.CFG)
+	backupResult, err := backup.StartBackup(ctx, clientset, dynamicClient, &config.CFG, targets)
 	duration := time.Since(startTime)
+	results := backupResult.Targets
+
+	lastBackupObjects.Set(float64(backupResult.ObjectsWritten))
+	lastBackupObjectErrors.Set(float64(backupResult.ObjectsFailed))
+	lastBackupFailures.Set(float64(len(backupResult.Failures)))
+	if backupResult.Partial() {
+		lastBackupPartial.Set(1)
+	} else {
+		lastBackupPartial.Set(0)
+	}
+
+	var failedTargets, retentionFailedTargets []string
+	for _, result := range results {
+		if result.Success {
+			lastBackupTargetStatus.WithLabelValues(result.Target).Set(1)
+		} else {
+			lastBackupTargetStatus.WithLabelValues(result.Target).Set(0)
+			failedTargets = append(failedTargets, result.Target)
+			continue
+		}
+		if config.CFG.Retention <= 0 {
+			continue
+		}
+		if result.RetentionError != "" {
+			lastRetentionStatus.WithLabelValues(result.Target).Set(0)
+			retentionFailedTargets = append(retentionFailedTargets, result.Target)
+		} else {
+			lastRetentionStatus.WithLabelValues(result.Target).Set(1)
+		}
+	}
 
 	if err != nil {
 		logger.Printf("Backup failed: %v", err)
-		lastBackupInfo = struct {
-			Status  string `json:"status"`
-			Message string `json:"message"`
-			Time    string `json:"time"`
-		}{
-			Status:  "failed",
-			Message: err.Error(),
-			Time:    startTime.Format(time.RFC3339),
-		}
+		setLastBackupInfo(newBackupInfo("failed", err.Error(), startTime, backupResult))
 		lastBackupStatus.Set(0)
 		return
 	}
 
-	if status {
+	if len(failedTargets) > 0 {
+		logger.Printf("Backup completed with errors in %v", duration)
+		setLastBackupInfo(newBackupInfo("failed", fmt.Sprintf("Backup completed with errors: upload failed for targets %s.", strings.Join(failedTargets, ", ")), startTime, backupResult))
+		lastBackupStatus.Set(0)
+	} else if backupResult.Partial() {
+		logger.Printf("Backup completed partially in %v", duration)
+		setLastBackupInfo(newBackupInfo("partial", fmt.Sprintf("Backup completed, but %d resources or objects could not be backed up.", len(backupResult.Failures)), startTime, backupResult))
+		lastBackupStatus.Set(0)
+	} else {
 		logger.Printf("Backup completed successfully in %v", duration)
-		lastBackupInfo = struct {
-			Status  string `json:"status"`
-			Message string `json:"message"`
-			Time    string `json:"time"`
-		}{
-			Status:  "success",
-			Message: "Backup completed successfully.",
-			Time:    startTime.Format(time.RFC3339),
+		message := "Backup completed successfully."
+		if len(retentionFailedTargets) > 0 {
+			message = fmt.Sprintf("Backup completed successfully, but old backups could not be cleaned up in targets %s.", strings.Join(retentionFailedTargets, ", "))
 		}
+		setLastBackupInfo(newBackupInfo("success", message, startTime, backupResult))
 		lastBackupStatus.Set(1)
 		lastBackupTime.Set(float64(startTime.Unix()))
 		lastBackupDuration.Set(duration.Seconds())
-	} else {
-		logger.Printf("Backup completed with errors in %v", duration)
-		lastBackupInfo = struct {
-			Status  string `json:"status"`
-			Message string `json:"message"`
-			Time    string `json:"time"`
-		}{
-			Status:  "failed",
-			Message: "Backup completed with errors.",
-			Time:    startTime.Format(time.RFC3339),
+	}
+
+	if config.CFG.VerifyAfterUpload {
+		verifyUploads(ctx, targets, results)
+	}
+}
+
+// setLastBackupInfo replaces the status reported for the last backup.
+func setLastBackupInfo(info backupInfo) {
+	lastBackupInfoLock.Lock()
+	defer lastBackupInfoLock.Unlock()
+	lastBackupInfo = info
+}
+
+// getLastBackupInfo returns a copy of the status reported for the last backup.
+func getLastBackupInfo() backupInfo {
+	lastBackupInfoLock.Lock()
+	defer lastBackupInfoLock.Unlock()
+	return lastBackupInfo
+}
+
+// newBackupInfo returns the status reported for a backup that started at startTime.
+func newBackupInfo(status, message string, startTime time.Time, result *backup.Result) backupInfo {
+	return backupInfo{
+		Status:         status,
+		Message:        message,
+		Time:           startTime.Format(time.RFC3339),
+		Targets:        result.Targets,
+		ObjectsWritten: result.ObjectsWritten,
+		ObjectsFailed:  result.ObjectsFailed,
+		Failures:       result.Failures,
+	}
+}
+
+// verifyUploads verifies the archives that were just uploaded successfully.
+func verifyUploads(ctx context.Context, targets []storage.Target, results []storage.UploadResult) {
+	keys := make(map[string]string, len(results))
+	for _, result := range results {
+		if result.Success {
+			keys[result.Target] = result.Key
 		}
-		lastBackupStatus.Set(0)
 	}
+
+	uploaded := make([]storage.Target, 0, len(keys))
+	for _, target := range targets {
+		if _, ok := keys[target.Name()]; ok {
+			uploaded = append(uploaded, target)
+		}
+	}
+	performVerify(ctx, uploaded, keys)
+}
+
+// logResourceFilters logs which resources backups include, since the default excludes also apply on top of
+// INCLUDE_RESOURCES.
+func logResourceFilters() {
+	excluded := "none"
+	if len(config.CFG.ExcludeResources) > 0 {
+		excluded = strings.Join(config.CFG.ExcludeResources, ",")
+	}
+	if len(config.CFG.IncludeResources) > 0 {
+		logger.Printf("Backing up resources matching %s, except those matching EXCLUDE_RESOURCES: %s", strings.Join(config.CFG.IncludeResources, ","), excluded)
+		return
+	}
+	logger.Printf("Backing up all resources except those matching EXCLUDE_RESOURCES: %s", excluded)
+}
+
+// performVerify verifies a backup in each target and updates metrics/status. The archive verified in a target is
+// taken from keys, then from the configuration, and defaults to the most recent backup.
+func performVerify(ctx context.Context, targets []storage.Target, keys map[string]string) []*verify.Result {
+	results := make([]*verify.Result, 0, len(targets))
+	for _, target := range targets {
+		key, ok := keys[target.Name()]
+		if !ok {
+			key = config.CFG.VerifyArchive
+		}
+
+		result := verify.Verify(ctx, target, key, &config.CFG)
+		if result.Valid {
+			lastVerifyStatus.WithLabelValues(target.Name()).Set(1)
+		} else {
+			lastVerifyStatus.WithLabelValues(target.Name()).Set(0)
+		}
+		lastVerifyTime.WithLabelValues(target.Name()).Set(float64(time.Now().Unix()))
+		results = append(results, result)
+	}
+
+	lastBackupInfoLock.Lock()
+	lastBackupInfo.Verification = results
+	lastBackupInfoLock.Unlock()
+	return results
+}
+
+// selectTargets returns the target with the given name, or every target if name is empty.
+func selectTargets(targets []storage.Target, name string) ([]storage.Target, error) {
+	if name == "" {
+		return targets, nil
+	}
+	for _, target := range targets {
+		if target.Name() == name {
+			return []storage.Target{target}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown target: %s", name)
+}
+
+// createTargets builds the storage targets described in the configuration.
+func createTargets(targetConfigs []config.TargetConfig) ([]storage.Target, error) {
+	targets := make([]storage.Target, 0, len(targetConfigs))
+	for _, targetConfig := range targetConfigs {
+		var target storage.Target
+		var err error
+		switch targetConfig.Type {
+		case "s3":
+			target, err = s3.NewTarget(targetConfig)
+		case "local":
+			target, err = local.NewTarget(targetConfig)
+		default:
+			err = fmt.Errorf("unknown target type: %s", targetConfig.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error creating target %s: %v", targetConfig.Name, err)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
 }
 
 // startHTTPServer starts an HTTP server for metrics and admin endpoints
-func startHTTPServer(clientset *kubernetes.Clientset, dynamicClient dynamic.Interface) *http.Server {
+func startHTTPServer(ctx context.Context, clientset *kubernetes.Clientset, dynamicClient dynamic.Interface, targets []storage.Target) *http.Server {
 	logger.Println("Setting up HTTP server...")
 	mux := http.NewServeMux()
 
@@ -208,15 +537,22 @@ func startHTTPServer(clientset *kubernetes.Clientset, dynamicClient dynamic.Inte
 	mux.HandleFunc("/version", versionInfo)
 	mux.HandleFunc("/backup", func(w http.ResponseWriter, r *http.Request) {
 		logger.Printf("HTTP request to /backup from %s", r.RemoteAddr)
-		if triggerAPITask(w, clientset, dynamicClient, "backup") {
+		if triggerAPITask(ctx, w, clientset, dynamicClient, targets, "backup") {
 			w.WriteHeader(http.StatusOK)
 			fmt.Fprintf(w, "Backup triggered successfully at %s.\n", time.Now().Format(time.RFC3339))
 		}
 	})
+	mux.HandleFunc("/verify", func(w http.ResponseWriter, r *http.Request) {
+		logger.Printf("HTTP request to /verify from %s", r.RemoteAddr)
+		if triggerAPITask(ctx, w, clientset, dynamicClient, targets, "verify") {
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprintf(w, "Verification triggered successfully at %s. Results are reported in /status.\n", time.Now().Format(time.RFC3339))
+		}
+	})
 	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
 		logger.Printf("HTTP request to /status from %s", r.RemoteAddr)
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(lastBackupInfo)
+		json.NewEncoder(w).Encode(getLastBackupInfo())
 	})
 
 	server := &http.Server{
@@ -250,6 +586,8 @@ func defaultPage(w http.ResponseWriter, _ *http.Request) {
 				<li><a href="/healthz">Health Check</a></li>
 				<li><a href="/version">Version</a></li>
 				<li><a href="/backup">Trigger Backup</a></li>
+				<li><a href="/verify">Verify Latest Backup</a></li>
+				<li><a href="/status">Status</a></li>
 			</ul>
 		</body>
 		</html>
@@ -286,7 +624,7 @@ func logRequestMiddleware(next http.Handler) http.Handler {
 }
 
 // triggerAPITask triggers a task based on the mode and returns true if the task was started
-func triggerAPITask(w http.ResponseWriter, clientset *kubernetes.Clientset, dynamicClient dynamic.Interface, mode string) bool {
+func triggerAPITask(ctx context.Context, w http.ResponseWriter, clientset *kubernetes.Clientset, dynamicClient dynamic.Interface, targets []storage.Target, mode string) bool {
 	taskLock.Lock()
 	defer taskLock.Unlock()
 
@@ -297,7 +635,9 @@ func triggerAPITask(w http.ResponseWriter, clientset *kubernetes.Clientset, dyna
 	}
 
 	isTaskRunning = true
+	apiTasks.Add(1)
 	go func() {
+		defer apiTasks.Done()
 		defer func() {
 			taskLock.Lock()
 			isTaskRunning = false
@@ -310,7 +650,14 @@ func triggerAPITask(w http.ResponseWriter, clientset *kubernetes.Clientset, dyna
 
 		switch mode {
 		case "backup":
-			performBackup(clientset, dynamicClient)
+			performBackup(ctx, clientset, dynamicClient, targets)
+		case "verify":
+			selected, err := selectTargets(targets, config.CFG.VerifyTarget)
+			if err != nil {
+				logger.Printf("Verification failed: %v", err)
+				break
+			}
+			performVerify(ctx, selected, nil)
 		default:
 			logger.Printf("Invalid task mode: %s", mode)
 			http.Error(w, "Invalid task mode", http.StatusBadRequest)
@@ -0,0 +1,155 @@
+package backup
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mattmattox/kubebackup/pkg/compression"
+	"github.com/mattmattox/kubebackup/pkg/config"
+	"github.com/mattmattox/kubebackup/pkg/encryption"
+	"github.com/mattmattox/kubebackup/pkg/manifest"
+	"github.com/mattmattox/kubebackup/pkg/redact"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ArchiveWriter serializes objects into a compressed, optionally encrypted, tar stream and records them in the
+// manifest. It is safe for concurrent use.
+type ArchiveWriter struct {
+	mu       sync.Mutex
+	tar      *tar.Writer
+	comp     io.WriteCloser
+	enc      io.WriteCloser
+	hash     hash.Hash
+	manifest *manifest.Manifest
+	modTime  time.Time
+	err      error
+
+	// Shared by every object written to the archive
+	redactor       *redact.Redactor
+	fieldEncrypter *encryption.FieldEncrypter
+}
+
+// NewArchiveWriter returns an ArchiveWriter that writes the archive to w.
+func NewArchiveWriter(w io.Writer, cfg *config.AppConfig) (*ArchiveWriter, error) {
+	redactor, err := redact.NewRedactor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	fieldEncrypter, err := encryption.NewFieldEncrypter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	a := &ArchiveWriter{
+		hash:           sha256.New(),
+		manifest:       manifest.New(),
+		modTime:        time.Now(),
+		redactor:       redactor,
+		fieldEncrypter: fieldEncrypter,
+	}
+
+	// Layers are tar -> compression -> age -> (checksum, output)
+	var out io.Writer = io.MultiWriter(a.hash, w)
+	if encryption.Enabled(cfg) {
+		enc, err := encryption.NewWriter(out, cfg)
+		if err != nil {
+			return nil, err
+		}
+		a.enc = enc
+		out = enc
+	}
+	comp, err := compression.NewWriter(out, cfg.Compression, cfg.CompressionLevel)
+	if err != nil {
+		return nil, err
+	}
+	a.comp = comp
+	a.tar = tar.NewWriter(a.comp)
+	return a, nil
+}
+
+// WriteObject adds the file data of the named object to the archive and the manifest.
+func (a *ArchiveWriter) WriteObject(archivePath string, resource schema.GroupVersionResource, namespace, name string, data []byte) error {
+	entry := manifest.NewEntry(archivePath, resource, namespace, name, data)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.writeFile(archivePath, data); err != nil {
+		return err
+	}
+	a.manifest.Add(entry)
+	return nil
+}
+
+// AddFailures records resources and objects that could not be backed up in the manifest.
+func (a *ArchiveWriter) AddFailures(failures []manifest.Failure) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, failure := range failures {
+		a.manifest.AddFailure(failure)
+	}
+}
+
+// writeFile writes a single file to the tar stream. The first error is kept and returned for every later write,
+// since the stream cannot recover from it.
+func (a *ArchiveWriter) writeFile(name string, data []byte) error {
+	if a.err != nil {
+		return a.err
+	}
+
+	header := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  a.modTime,
+		Typeflag: tar.TypeReg,
+	}
+	if err := a.tar.WriteHeader(header); err != nil {
+		a.err = fmt.Errorf("error writing tar header: %v", err)
+		return a.err
+	}
+	if _, err := a.tar.Write(data); err != nil {
+		a.err = fmt.Errorf("error writing file content to tar: %v", err)
+		return a.err
+	}
+	return nil
+}
+
+// Err returns the error that stopped the archive stream, if any.
+func (a *ArchiveWriter) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// Close writes the manifest as the last entry, flushes every layer and returns the SHA-256 of the archive.
+func (a *ArchiveWriter) Close() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	data, err := a.manifest.Encode()
+	if err != nil {
+		return "", err
+	}
+	if err := a.writeFile(manifest.FileName, data); err != nil {
+		return "", err
+	}
+	log.Infof("Wrote manifest with %d objects.", len(a.manifest.Objects))
+
+	if err := a.tar.Close(); err != nil {
+		return "", fmt.Errorf("error closing tar writer: %v", err)
+	}
+	if err := a.comp.Close(); err != nil {
+		return "", fmt.Errorf("error closing compressed writer: %v", err)
+	}
+	if a.enc != nil {
+		if err := a.enc.Close(); err != nil {
+			return "", fmt.Errorf("error closing encrypted writer: %v", err)
+		}
+	}
+	return hex.EncodeToString(a.hash.Sum(nil)), nil
+}
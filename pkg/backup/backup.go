@@ -1,375 +1,384 @@
 package backup
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
-	"io"
-	"os"
-	"path/filepath"
-	"sync"
+	"path"
 	"time"
 
+	"github.com/mattmattox/kubebackup/pkg/compression"
 	"github.com/mattmattox/kubebackup/pkg/config"
+	"github.com/mattmattox/kubebackup/pkg/encryption"
 	"github.com/mattmattox/kubebackup/pkg/k8s"
 	"github.com/mattmattox/kubebackup/pkg/logging"
-	"github.com/mattmattox/kubebackup/pkg/s3"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"github.com/mattmattox/kubebackup/pkg/signing"
+	"github.com/mattmattox/kubebackup/pkg/storage"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
 )
 
 var log = logging.SetupLogging()
 
-func StartBackup(clientset *kubernetes.Clientset, dynamicClient dynamic.Interface, cfg *config.AppConfig) (bool, error) {
+// StartBackup exports the cluster, uploads the archive to every target and returns the result of the backup,
+// including the per-target upload results. Resources and objects that cannot be backed up are recorded in the
+// result and the archive manifest rather than failing the backup. If ctx is canceled or cfg.BackupTimeout passes
+// before the archive is complete, the uploads are aborted so no partial archive is stored.
+func StartBackup(ctx context.Context, clientset *kubernetes.Clientset, dynamicClient dynamic.Interface, cfg *config.AppConfig, targets []storage.Target) (*Result, error) {
+	if cfg.BackupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.BackupTimeout)
+		defer cancel()
+	}
+	result := &Result{}
+
 	log.Infoln("Fetching namespaces...")
-	namespaces, err := k8s.GetNamespaces(clientset)
+	namespaces, err := k8s.GetNamespaces(ctx, clientset, cfg.NamespaceLabelSelector)
 	if err != nil {
-		return false, fmt.Errorf("error fetching namespaces: %v", err)
+		return result, fmt.Errorf("error fetching namespaces: %v", err)
 	}
 	log.Infof("Found %d namespaces.", len(namespaces))
+	namespaces = filterNamespaces(namespaces, cfg)
+	log.Infof("Backing up %d namespaces.", len(namespaces))
 
-	// Create a temporary directory for storing backup files
-	tmpDir, err := os.MkdirTemp("", "kubebackup-")
-	if err != nil {
-		return false, fmt.Errorf("error creating temporary directory: %v", err)
+	var signingKey ed25519.PrivateKey
+	if cfg.SigningKeyFile != "" {
+		signingKey, err = signing.LoadPrivateKey(cfg.SigningKeyFile)
+		if err != nil {
+			return result, err
+		}
 	}
-	log.Infof("Created temporary directory: %s", tmpDir)
 
-	// Ensure the temporary directory is cleaned up after the tar operation
-	defer func() {
-		log.Infof("Cleaning up temporary directory: %s", tmpDir)
-		os.RemoveAll(tmpDir)
-	}()
+	// Stream the archive to every target while objects are serialized into it
+	key := archiveKey(cfg)
+	stream := storage.NewStream(ctx, targets, key)
+	archive, err := NewArchiveWriter(stream, cfg)
+	if err != nil {
+		stream.Abort(err)
+		return result, err
+	}
 
 	// Process cluster-scoped resources
 	log.Infoln("Fetching cluster-scoped resources...")
-	clusterScopedResources, err := k8s.GetClusterScopedResources(clientset)
+	clusterScopedResources, failedGroups, err := k8s.GetClusterScopedResources(clientset)
 	if err != nil {
-		return false, fmt.Errorf("error fetching cluster-scoped resources: %v", err)
+		stream.Abort(err)
+		return result, fmt.Errorf("error fetching cluster-scoped resources: %v", err)
 	}
+	result.addDiscoveryFailures(failedGroups)
 	log.Infof("Found %d cluster-scoped resources.", len(clusterScopedResources))
+	clusterScopedResources = filterResources(clusterScopedResources, cfg)
+	log.Infof("Backing up %d cluster-scoped resources.", len(clusterScopedResources))
 
-	clusterScopedDir := filepath.Join(tmpDir, "cluster-scoped")
 	log.Infoln("Processing cluster-scoped resources...")
-	if err := ProcessClusterScopedResources(dynamicClient, clusterScopedResources, clusterScopedDir); err != nil {
-		return false, fmt.Errorf("error processing cluster-scoped resources: %v", err)
+	if err := ProcessClusterScopedResources(ctx, dynamicClient, namespaces, clusterScopedResources, archive, result, cfg); err != nil {
+		stream.Abort(err)
+		return result, fmt.Errorf("error processing cluster-scoped resources: %v", err)
 	}
-	log.Infof("Cluster-scoped resources processed successfully.")
+	log.Infof("Cluster-scoped resources processed.")
 
 	// Process namespace-scoped resources
 	log.Infoln("Fetching namespaced resources...")
-	namespacedResources, err := k8s.GetNamespaceScopedResources(clientset)
+	namespacedResources, failedGroups, err := k8s.GetNamespaceScopedResources(clientset)
 	if err != nil {
-		return false, fmt.Errorf("error fetching namespaced resources: %v", err)
+		stream.Abort(err)
+		return result, fmt.Errorf("error fetching namespaced resources: %v", err)
 	}
+	result.addDiscoveryFailures(failedGroups)
 	log.Infof("Found %d namespaced resources.", len(namespacedResources))
+	namespacedResources = filterResources(namespacedResources, cfg)
+	log.Infof("Backing up %d namespaced resources.", len(namespacedResources))
 
-	namespaceScopedDir := filepath.Join(tmpDir, "namespace-scoped")
-	log.Infoln("Processing namespace-scoped resources...")
-	if err := ProcessNamespaces(dynamicClient, namespaces, namespacedResources, namespaceScopedDir); err != nil {
-		return false, fmt.Errorf("error processing namespace-scoped resources: %v", err)
+	log.Infof("Processing namespace-scoped resources with the %s strategy...", cfg.BackupStrategy)
+	processNamespaced := ProcessNamespaces
+	if cfg.BackupStrategy == "cluster" {
+		processNamespaced = ProcessNamespacedResources
 	}
-	log.Infof("Namespace-scoped resources processed successfully.")
-
-	// Compress the backup directory
-	tarFilePath, err := CompressBackup(tmpDir)
-	if err != nil {
-		return false, fmt.Errorf("error during compression: %v", err)
+	if err := processNamespaced(ctx, dynamicClient, namespaces, namespacedResources, archive, result, cfg); err != nil {
+		stream.Abort(err)
+		return result, fmt.Errorf("error processing namespace-scoped resources: %v", err)
 	}
+	log.Infof("Namespace-scoped resources processed.")
 
-	if cfg.BackupTarget == "s3" {
-		// Compress and upload to S3
-		log.Infoln("Uploading backup to S3...")
-		if err := s3.UploadToS3(tarFilePath, cfg.S3Bucket, cfg.S3Folder, cfg.S3Region, cfg.S3AccessKeyID, cfg.S3SecretAccessKey, cfg.S3Endpoint, cfg.S3CustomCA, cfg.S3DisableSSL, cfg.Retention); err != nil {
-			return false, fmt.Errorf("error compressing and uploading to S3: %v", err)
-		}
-
-		// Delete the tarball after uploading to S3
-		if err := os.Remove(tarFilePath); err != nil {
-			log.Errorf("Failed to delete tarball: %v", err)
-		}
-	} else {
-		log.Infof("Backup tarball created at: %s", tarFilePath)
+	// Stop before the archive is finished so an incomplete backup is never stored
+	if err := ctx.Err(); err != nil {
+		stream.Abort(err)
+		return result, fmt.Errorf("backup aborted: %v", err)
 	}
 
-	// Ensure cleanup of the temporary directory
-	defer func() {
-		if cleanupErr := CleanupTmpDir(tmpDir); cleanupErr != nil {
-			log.Errorf("Failed to clean up temporary directory: %v", cleanupErr)
-		}
-	}()
-
-	log.Infof("Backup process completed successfully.")
-	return true, nil
-}
-
-func ProcessNamespaces(dynamicClient dynamic.Interface, namespaces []string, namespacedResources []schema.GroupVersionResource, baseDir string) error {
-	var wg sync.WaitGroup
-	var processErr error
-	mu := &sync.Mutex{}
-
-	for _, ns := range namespaces {
-		wg.Add(1)
-		go func(ns string) {
-			defer wg.Done()
-			if err := processNamespace(dynamicClient, ns, namespacedResources, baseDir); err != nil {
-				mu.Lock()
-				defer mu.Unlock()
-				processErr = fmt.Errorf("error processing namespace '%s': %w", ns, err)
-				log.Errorf("Error processing namespace '%s': %v", ns, err)
-			}
-		}(ns)
+	// A write error means every target failed, so there is nothing left to upload to
+	if err := archive.Err(); err != nil {
+		result.Targets = stream.Finish(ctx, storage.Archive{Key: key}, 0)
+		return result, err
 	}
 
-	wg.Wait()
-
-	return processErr
-}
-
-func ProcessClusterScopedResources(dynamicClient dynamic.Interface, resources []schema.GroupVersionResource, baseDir string) error {
-	// Create the base directory for cluster-scoped resources
-	if err := os.MkdirAll(baseDir, 0755); err != nil {
-		return fmt.Errorf("error creating directory '%s': %v", baseDir, err)
+	// Finish the archive with the manifest, then complete the uploads with the checksum and signature sidecars
+	archive.AddFailures(result.Failures)
+	checksum, err := archive.Close()
+	if err != nil {
+		stream.Abort(err)
+		return result, fmt.Errorf("error finishing archive: %v", err)
 	}
+	log.Infof("Backup %s has SHA-256 checksum %s", key, checksum)
 
-	for _, resource := range resources {
-		log.Infof("Processing cluster-scoped resource: %s", resource.Resource)
-
-		// Fetch objects for the resource
-		objects, err := GetClusterObjects(dynamicClient, resource)
-		if err != nil {
-			log.Errorf("Error fetching objects for resource '%s': %v", resource.Resource, err)
-			continue
-		}
-
-		// Skip if no objects are found
-		if len(objects) == 0 {
-			log.Infof("No objects found for resource '%s'", resource.Resource)
-			continue
-		}
+	uploaded := storage.Archive{Key: key, Checksum: checksum}
+	if signingKey != nil {
+		uploaded.Signature = signing.Sign(signingKey, checksum)
+	}
+	result.Targets = stream.Finish(ctx, uploaded, cfg.Retention)
 
-		// Create a directory for the resource
-		resourceDir := filepath.Join(baseDir, resource.Resource)
-		if err := os.MkdirAll(resourceDir, 0755); err != nil {
-			log.Errorf("Error creating directory '%s': %v", resourceDir, err)
-			continue
+	failed := 0
+	for _, target := range result.Targets {
+		if !target.Success {
+			failed++
 		}
+	}
+	if failed > 0 && failed == len(result.Targets) {
+		return result, fmt.Errorf("error uploading backup: all %d targets failed", failed)
+	}
 
-		for _, object := range objects {
-			objectName := object.GetName()
-			objectFile := filepath.Join(resourceDir, objectName+".yaml")
-
-			objectData, err := object.MarshalJSON()
-			if err != nil {
-				log.Errorf("Error marshalling object '%s': %v", objectName, err)
-				continue
-			}
+	if failed > 0 {
+		log.Warnf("Backup process completed, but %d of %d targets failed.", failed, len(result.Targets))
+		return result, nil
+	}
 
-			if err := writeObject(objectData, objectFile); err != nil {
-				log.Errorf("Error writing object '%s' to file '%s': %v", objectName, objectFile, err)
-				continue
-			}
-		}
+	if result.Partial() {
+		log.Warnf("Backup process completed with %d errors: %d objects written, %d objects failed.", len(result.Failures), result.ObjectsWritten, result.ObjectsFailed)
+		return result, nil
 	}
 
-	return nil
+	log.Infof("Backup process completed successfully with %d objects.", result.ObjectsWritten)
+	return result, nil
 }
 
-func GetClusterObjects(dynamicClient dynamic.Interface, resource schema.GroupVersionResource) ([]*unstructured.Unstructured, error) {
-	// Fetch the list of objects for the resource
-	resourceList, err := dynamicClient.Resource(resource).List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("error fetching resource list for '%s': %v", resource.Resource, err)
+// ProcessNamespaces lists every namespaced resource in each namespace, processing up to cfg.Workers namespace and
+// resource pairs at a time.
+func ProcessNamespaces(ctx context.Context, dynamicClient dynamic.Interface, namespaces []string, namespacedResources []schema.GroupVersionResource, archive *ArchiveWriter, result *Result, cfg *config.AppConfig) error {
+	jobs := make([]func(), 0, len(namespaces)*len(namespacedResources))
+	for _, ns := range namespaces {
+		for _, resource := range namespacedResources {
+			ns, resource := ns, resource
+			jobs = append(jobs, func() {
+				if err := processResource(ctx, dynamicClient, ns, resource, archive, result, cfg); err != nil {
+					log.Errorf("Error processing resource '%s' in namespace '%s': %v", resource.Resource, ns, err)
+				}
+			})
+		}
 	}
 
-	// Convert items to []*unstructured.Unstructured
-	objects := make([]*unstructured.Unstructured, 0, len(resourceList.Items))
-	for i := range resourceList.Items {
-		objects = append(objects, &resourceList.Items[i])
-	}
+	runWorkers(ctx, cfg.Workers, jobs)
 
-	return objects, nil
+	return nil
 }
 
-func processNamespace(dynamicClient dynamic.Interface, ns string, namespacedResources []schema.GroupVersionResource, baseDir string) error {
-	log.Infof("Processing namespace %s", ns)
-	namespaceDir := fmt.Sprintf("%s/%s", baseDir, ns)
-	if err := os.MkdirAll(namespaceDir, 0755); err != nil {
-		return fmt.Errorf("error creating directory '%s': %v", namespaceDir, err)
+// ProcessNamespacedResources lists each namespaced resource once across all namespaces and files every object under
+// its own namespace, processing up to cfg.Workers resources at a time. Objects in namespaces that are not in
+// namespaces are skipped.
+func ProcessNamespacedResources(ctx context.Context, dynamicClient dynamic.Interface, namespaces []string, namespacedResources []schema.GroupVersionResource, archive *ArchiveWriter, result *Result, cfg *config.AppConfig) error {
+	included := make(map[string]bool, len(namespaces))
+	for _, ns := range namespaces {
+		included[ns] = true
 	}
 
+	jobs := make([]func(), 0, len(namespacedResources))
 	for _, resource := range namespacedResources {
-		if err := processResource(dynamicClient, ns, resource, namespaceDir); err != nil {
-			log.Errorf("Error processing resource '%s' in namespace '%s': %v", resource.Resource, ns, err)
-		}
+		resource := resource
+		jobs = append(jobs, func() {
+			if err := processClusterWideResource(ctx, dynamicClient, included, resource, archive, result, cfg); err != nil {
+				log.Errorf("Error processing resource '%s': %v", resource.Resource, err)
+			}
+		})
 	}
-	return nil
-}
 
-func processResource(dynamicClient dynamic.Interface, ns string, resource schema.GroupVersionResource, namespaceDir string) error {
-	log.Infof("Processing resource %s in namespace %s", resource.Resource, ns)
-	objects, err := k8s.GetNamespaceObjects(dynamicClient, ns, resource, "")
-	if err != nil {
-		return fmt.Errorf("error fetching objects for resource '%s' in namespace '%s': %v", resource.Resource, ns, err)
-	}
+	runWorkers(ctx, cfg.Workers, jobs)
 
-	log.Infof("Found %d objects for resource %s in namespace %s", len(objects), resource.Resource, ns)
-	for _, object := range objects {
-		if err := processObject(dynamicClient, ns, resource, object, namespaceDir); err != nil {
-			log.Errorf("Error processing object '%s' of resource '%s': %v", object, resource.Resource, err)
-		}
-	}
 	return nil
 }
 
-func processObject(dynamicClient dynamic.Interface, ns string, resource schema.GroupVersionResource, object string, namespaceDir string) error {
-	log.Infof("Processing object %s of resource %s in namespace %s", object, resource.Resource, ns)
+func processClusterWideResource(ctx context.Context, dynamicClient dynamic.Interface, namespaces map[string]bool, resource schema.GroupVersionResource, archive *ArchiveWriter, result *Result, cfg *config.AppConfig) error {
+	log.Infof("Processing resource %s in all namespaces", resource.Resource)
 
-	// Create the directory for the resource
-	objectDir := filepath.Join(namespaceDir, resource.Resource)
-	if err := os.MkdirAll(objectDir, 0755); err != nil {
-		return fmt.Errorf("error creating directory '%s': %v", objectDir, err)
-	}
-
-	// Fetch the object data
-	objectData, objectName, err := getObject(dynamicClient, ns, resource, object)
+	counts := make(map[string]int)
+	err := k8s.ListObjects(ctx, dynamicClient, "", resource, cfg.ListPageSize, func(object *unstructured.Unstructured) error {
+		ns := object.GetNamespace()
+		if !namespaces[ns] {
+			return nil
+		}
+		counts[ns]++
+		writeListedObject(object, resource, ns, archive, result, cfg)
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("error fetching object '%s': %v", object, err)
+		result.addFailure(resource, "", "", err)
+		return fmt.Errorf("error fetching objects for resource '%s': %v", resource.Resource, err)
 	}
 
-	// Write the object data to a YAML file
-	objectFilePath := filepath.Join(objectDir, objectName+".yaml")
-	if err := writeObject(objectData, objectFilePath); err != nil {
-		return fmt.Errorf("error writing object '%s': %v", objectName, err)
+	for ns, count := range counts {
+		log.Infof("Found %d objects for resource %s in namespace %s", count, resource.Resource, ns)
 	}
 	return nil
 }
 
-func getObject(dynamicClient dynamic.Interface, ns string, resource schema.GroupVersionResource, object string) ([]byte, string, error) {
-	// Fetch the object
-	objectData, err := dynamicClient.Resource(resource).Namespace(ns).Get(context.TODO(), object, metav1.GetOptions{})
-	if err != nil {
-		return nil, "", fmt.Errorf("error fetching object '%s': %v", object, err)
+// ProcessClusterScopedResources lists every cluster-scoped resource, processing up to cfg.Workers resources at a time.
+// Only the Namespace objects of the namespaces being backed up are archived.
+func ProcessClusterScopedResources(ctx context.Context, dynamicClient dynamic.Interface, namespaces []string, resources []schema.GroupVersionResource, archive *ArchiveWriter, result *Result, cfg *config.AppConfig) error {
+	included := make(map[string]bool, len(namespaces))
+	for _, ns := range namespaces {
+		included[ns] = true
 	}
 
-	// Extract object name and marshal data to JSON
-	objectName := objectData.GetName()
-	objectJSON, err := json.Marshal(objectData.Object)
-	if err != nil {
-		return nil, "", fmt.Errorf("error converting object '%s' to JSON: %v", object, err)
+	jobs := make([]func(), 0, len(resources))
+	for _, resource := range resources {
+		resource := resource
+		jobs = append(jobs, func() {
+			if err := processClusterScopedResource(ctx, dynamicClient, included, resource, archive, result, cfg); err != nil {
+				log.Errorf("Error fetching objects for resource '%s': %v", resource.Resource, err)
+			}
+		})
 	}
-	return objectJSON, objectName, nil
+
+	runWorkers(ctx, cfg.Workers, jobs)
+
+	return nil
 }
 
-func writeObject(objectData []byte, objectFile string) error {
-	// Create the file
-	f, err := os.Create(objectFile)
+func processClusterScopedResource(ctx context.Context, dynamicClient dynamic.Interface, namespaces map[string]bool, resource schema.GroupVersionResource, archive *ArchiveWriter, result *Result, cfg *config.AppConfig) error {
+	log.Infof("Processing cluster-scoped resource: %s", resource.Resource)
+
+	// Write objects straight from the list pages
+	count := 0
+	err := k8s.ListObjects(ctx, dynamicClient, "", resource, cfg.ListPageSize, func(object *unstructured.Unstructured) error {
+		if resource.Group == "" && resource.Resource == "namespaces" && !namespaces[object.GetName()] {
+			return nil
+		}
+		count++
+		writeListedObject(object, resource, "", archive, result, cfg)
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("error creating file '%s': %v", objectFile, err)
+		result.addFailure(resource, "", "", err)
+		return err
 	}
-	defer f.Close()
 
-	// Write the data to the file
-	if _, err := f.Write(objectData); err != nil {
-		return fmt.Errorf("error writing to file '%s': %v", objectFile, err)
+	// Skip if no objects are found
+	if count == 0 {
+		log.Infof("No objects found for resource '%s'", resource.Resource)
 	}
-
 	return nil
 }
 
-// CompressBackup creates a tarball of the source directory and compresses it using gzip.
-func CompressBackup(srcDir string) (string, error) {
-	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	tarFilePath := filepath.Join("/tmp/", fmt.Sprintf("kubebackup_%s.tar.gz", timestamp))
-
-	log.Debugf("Starting compression process for directory: %s", srcDir)
-	log.Debugf("Generated tarball file path: %s", tarFilePath)
+func processResource(ctx context.Context, dynamicClient dynamic.Interface, ns string, resource schema.GroupVersionResource, archive *ArchiveWriter, result *Result, cfg *config.AppConfig) error {
+	log.Infof("Processing resource %s in namespace %s", resource.Resource, ns)
 
-	// Create the tarball
-	log.Infof("Creating tarball at: %s", tarFilePath)
-	err := createTarball(srcDir, tarFilePath)
+	// Write objects straight from the list pages instead of fetching each one again
+	count := 0
+	err := k8s.ListObjects(ctx, dynamicClient, ns, resource, cfg.ListPageSize, func(object *unstructured.Unstructured) error {
+		count++
+		writeListedObject(object, resource, ns, archive, result, cfg)
+		return nil
+	})
 	if err != nil {
-		log.Errorf("Error during tarball creation for directory %s: %v", srcDir, err)
-		return "", fmt.Errorf("error creating tarball: %v", err)
+		result.addFailure(resource, ns, "", err)
+		return fmt.Errorf("error fetching objects for resource '%s' in namespace '%s': %v", resource.Resource, ns, err)
 	}
 
-	log.Infof("Successfully created tarball: %s", tarFilePath)
-	return tarFilePath, nil
+	log.Infof("Found %d objects for resource %s in namespace %s", count, resource.Resource, ns)
+	return nil
 }
 
-func createTarball(srcDir, tarFilePath string) error {
-	tarFile, err := os.Create(tarFilePath)
-	if err != nil {
-		return fmt.Errorf("error creating tar file: %v", err)
+// writeListedObject writes an object to the archive and records the outcome in result.
+func writeListedObject(object *unstructured.Unstructured, resource schema.GroupVersionResource, namespace string, archive *ArchiveWriter, result *Result, cfg *config.AppConfig) {
+	if err := writeArchivedObject(object, resource, namespace, archive, cfg); err != nil {
+		log.Errorf("Error writing object '%s' of resource '%s': %v", object.GetName(), resource.Resource, err)
+		result.addFailure(resource, namespace, object.GetName(), err)
+		return
 	}
-	defer tarFile.Close()
-
-	gzipWriter := gzip.NewWriter(tarFile)
-	defer gzipWriter.Close()
-
-	tarWriter := tar.NewWriter(gzipWriter)
-	defer tarWriter.Close()
+	result.addWritten()
+}
 
-	return filepath.Walk(srcDir, func(file string, info os.FileInfo, err error) error {
-		if err != nil {
-			return fmt.Errorf("error walking file path: %v", err)
+// prepareObject returns a copy of the object with the configured transformations applied.
+func prepareObject(object *unstructured.Unstructured, resource schema.GroupVersionResource, archive *ArchiveWriter, cfg *config.AppConfig) (*unstructured.Unstructured, error) {
+	prepared := object.DeepCopy()
+	if cfg.Sanitize {
+		sanitizeObject(prepared, cfg.SanitizeFields)
+	}
+	if archive.redactor != nil {
+		if err := archive.redactor.RedactObject(prepared, resource); err != nil {
+			return nil, err
 		}
-
-		// Skip the tarball file itself
-		if file == tarFilePath {
-			return nil
+	}
+	if archive.fieldEncrypter != nil {
+		if err := archive.fieldEncrypter.EncryptObject(prepared, resource); err != nil {
+			return nil, err
 		}
+	}
+	return prepared, nil
+}
 
-		// Create a tar header
-		relPath, err := filepath.Rel(srcDir, file)
-		if err != nil {
-			return fmt.Errorf("error calculating relative path: %v", err)
-		}
+// OutputExtensions returns the file extensions written for an output format.
+func OutputExtensions(format string) []string {
+	switch format {
+	case "json":
+		return []string{".json"}
+	case "both":
+		return []string{".yaml", ".json"}
+	default:
+		return []string{".yaml"}
+	}
+}
 
-		header, err := tar.FileInfoHeader(info, relPath)
+// encodeObject serializes an object in the format matching the file extension.
+func encodeObject(object *unstructured.Unstructured, ext string) ([]byte, error) {
+	if ext == ".json" {
+		objectJSON, err := json.Marshal(object.Object)
 		if err != nil {
-			return fmt.Errorf("error creating tar header: %v", err)
+			return nil, fmt.Errorf("error converting object '%s' to JSON: %v", object.GetName(), err)
 		}
-		header.Name = relPath
+		return objectJSON, nil
+	}
 
-		// Write the header
-		if err := tarWriter.WriteHeader(header); err != nil {
-			return fmt.Errorf("error writing tar header: %v", err)
-		}
+	objectYAML, err := yaml.Marshal(object.Object)
+	if err != nil {
+		return nil, fmt.Errorf("error converting object '%s' to YAML: %v", object.GetName(), err)
+	}
+	return objectYAML, nil
+}
 
-		// Skip directories
-		if info.IsDir() {
-			return nil
-		}
+// writeArchivedObject prepares an object and adds it to the archive once per configured output format.
+// Cluster-scoped objects, with an empty namespace, are stored under cluster-scoped/<resource>/ and
+// namespaced objects under namespace-scoped/<namespace>/<resource>/.
+func writeArchivedObject(object *unstructured.Unstructured, resource schema.GroupVersionResource, namespace string, archive *ArchiveWriter, cfg *config.AppConfig) error {
+	prepared, err := prepareObject(object, resource, archive, cfg)
+	if err != nil {
+		return err
+	}
 
-		// Write the file content
-		fileHandle, err := os.Open(file)
+	objectDir := path.Join("cluster-scoped", resource.Resource)
+	if namespace != "" {
+		objectDir = path.Join("namespace-scoped", namespace, resource.Resource)
+	}
+	for _, ext := range OutputExtensions(cfg.OutputFormat) {
+		objectData, err := encodeObject(prepared, ext)
 		if err != nil {
-			return fmt.Errorf("error opening file: %v", err)
+			return err
 		}
-		defer fileHandle.Close()
-
-		if _, err := io.Copy(tarWriter, fileHandle); err != nil {
-			return fmt.Errorf("error writing file content to tar: %v", err)
+		if err := archive.WriteObject(path.Join(objectDir, prepared.GetName()+ext), resource, namespace, prepared.GetName(), objectData); err != nil {
+			return err
 		}
-
-		return nil
-	})
+	}
+	return nil
 }
 
-func CleanupTmpDir(tmpDir string) error {
-	log.Infof("Cleaning up temporary directory: %s", tmpDir)
-
-	// Remove the temporary directory and its contents
-	if err := os.RemoveAll(tmpDir); err != nil {
-		return fmt.Errorf("error cleaning up temporary directory '%s': %v", tmpDir, err)
+// archiveKey returns the name of a new backup archive.
+func archiveKey(cfg *config.AppConfig) string {
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
+	key := storage.BackupPrefix + timestamp + compression.Extension(cfg.Compression)
+	if encryption.Enabled(cfg) {
+		key += encryption.Extension
 	}
-
-	log.Infof("Temporary directory %s cleaned up successfully.", tmpDir)
-	return nil
+	return key
 }
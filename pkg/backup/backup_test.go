@@ -0,0 +1,105 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/mattmattox/kubebackup/pkg/config"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+const (
+	benchNamespaces         = 50
+	benchResources          = 20
+	benchObjectsPerResource = 10
+)
+
+// newBenchClient returns a fake dynamic client holding benchObjectsPerResource objects of each of benchResources
+// resources in each of benchNamespaces namespaces.
+func newBenchClient(b *testing.B) (dynamic.Interface, []string, []schema.GroupVersionResource) {
+	b.Helper()
+
+	namespaces := make([]string, benchNamespaces)
+	for i := range namespaces {
+		namespaces[i] = fmt.Sprintf("namespace-%d", i)
+	}
+
+	resources := make([]schema.GroupVersionResource, benchResources)
+	listKinds := make(map[schema.GroupVersionResource]string, benchResources)
+	for i := range resources {
+		resources[i] = schema.GroupVersionResource{Group: "bench.kubebackup.io", Version: "v1", Resource: fmt.Sprintf("widgets%d", i)}
+		listKinds[resources[i]] = fmt.Sprintf("Widget%dList", i)
+	}
+
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds)
+	for i, resource := range resources {
+		for _, ns := range namespaces {
+			for j := 0; j < benchObjectsPerResource; j++ {
+				object := &unstructured.Unstructured{Object: map[string]interface{}{
+					"apiVersion": resource.GroupVersion().String(),
+					"kind":       fmt.Sprintf("Widget%d", i),
+					"metadata": map[string]interface{}{
+						"name":      fmt.Sprintf("widget-%d", j),
+						"namespace": ns,
+					},
+					"spec": map[string]interface{}{
+						"replicas": int64(j),
+						"image":    "registry.example.com/widget:latest",
+					},
+				}}
+				if _, err := client.Resource(resource).Namespace(ns).Create(context.Background(), object, metav1.CreateOptions{}); err != nil {
+					b.Fatalf("error seeding %s/%s: %v", ns, resource.Resource, err)
+				}
+			}
+		}
+	}
+	return client, namespaces, resources
+}
+
+func benchmarkProcess(b *testing.B, process func(context.Context, dynamic.Interface, []string, []schema.GroupVersionResource, *ArchiveWriter, *Result, *config.AppConfig) error) {
+	level := log.GetLevel()
+	log.SetLevel(logrus.WarnLevel)
+	defer log.SetLevel(level)
+
+	client, namespaces, resources := newBenchClient(b)
+	cfg := &config.AppConfig{
+		Workers:      10,
+		ListPageSize: 500,
+		OutputFormat: "yaml",
+		Compression:  "gzip",
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		archive, err := NewArchiveWriter(io.Discard, cfg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		result := &Result{}
+		if err := process(context.Background(), client, namespaces, resources, archive, result, cfg); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := archive.Close(); err != nil {
+			b.Fatal(err)
+		}
+		if want := benchNamespaces * benchResources * benchObjectsPerResource; result.ObjectsWritten != want {
+			b.Fatalf("wrote %d objects, want %d", result.ObjectsWritten, want)
+		}
+	}
+}
+
+func BenchmarkProcessNamespaces(b *testing.B) {
+	benchmarkProcess(b, ProcessNamespaces)
+}
+
+func BenchmarkProcessNamespacedResources(b *testing.B) {
+	benchmarkProcess(b, ProcessNamespacedResources)
+}
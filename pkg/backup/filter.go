@@ -0,0 +1,54 @@
+package backup
+
+import (
+	"fmt"
+
+	"github.com/mattmattox/kubebackup/pkg/config"
+	"github.com/mattmattox/kubebackup/pkg/filter"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ValidateFilters ensures the namespace and resource filters in cfg are well formed.
+func ValidateFilters(cfg *config.AppConfig) error {
+	for _, patterns := range [][]string{
+		cfg.IncludeNamespaces, cfg.ExcludeNamespaces,
+		cfg.IncludeResources, cfg.ExcludeResources,
+	} {
+		if err := filter.ValidatePatterns(patterns); err != nil {
+			return err
+		}
+	}
+	if _, err := labels.Parse(cfg.NamespaceLabelSelector); err != nil {
+		return fmt.Errorf("invalid namespace label selector '%s': %v", cfg.NamespaceLabelSelector, err)
+	}
+	return nil
+}
+
+// filterNamespaces returns the namespaces allowed by the include and exclude patterns in cfg.
+func filterNamespaces(namespaces []string, cfg *config.AppConfig) []string {
+	filtered := make([]string, 0, len(namespaces))
+	for _, ns := range namespaces {
+		if filter.Allowed(cfg.IncludeNamespaces, cfg.ExcludeNamespaces, ns) {
+			filtered = append(filtered, ns)
+		} else {
+			log.Debugf("Skipping namespace '%s': excluded by namespace filters", ns)
+		}
+	}
+	return filtered
+}
+
+// filterResources returns the resources allowed by the include and exclude patterns in cfg. Resources are matched
+// as "resource.group", or just "resource" for the core group.
+func filterResources(resources []schema.GroupVersionResource, cfg *config.AppConfig) []schema.GroupVersionResource {
+	filtered := make([]schema.GroupVersionResource, 0, len(resources))
+	for _, resource := range resources {
+		name := resource.GroupResource().String()
+		if filter.Allowed(cfg.IncludeResources, cfg.ExcludeResources, name) {
+			filtered = append(filtered, resource)
+		} else {
+			log.Debugf("Skipping resource '%s': excluded by resource filters", name)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,83 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mattmattox/kubebackup/pkg/manifest"
+	"github.com/mattmattox/kubebackup/pkg/storage"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Result is the outcome of a backup: how many objects were written, which resources and objects could not be
+// backed up, and the upload to each target.
+type Result struct {
+	mu sync.Mutex
+
+	ObjectsWritten int                    `json:"objectsWritten"`
+	ObjectsFailed  int                    `json:"objectsFailed"`
+	Failures       []manifest.Failure     `json:"failures,omitempty"`
+	Targets        []storage.UploadResult `json:"targets,omitempty"`
+}
+
+// Partial reports whether some resources or objects are missing from the backup.
+func (r *Result) Partial() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.Failures) > 0
+}
+
+// addWritten counts an object written to the archive.
+func (r *Result) addWritten() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ObjectsWritten++
+}
+
+// addFailure records a resource, or a single object when name is set, that could not be backed up.
+func (r *Result) addFailure(resource schema.GroupVersionResource, namespace, name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if name != "" {
+		r.ObjectsFailed++
+	}
+	r.Failures = append(r.Failures, manifest.Failure{
+		Group:     resource.Group,
+		Version:   resource.Version,
+		Resource:  resource.Resource,
+		Namespace: namespace,
+		Name:      name,
+		Error:     err.Error(),
+	})
+}
+
+// addDiscoveryFailures records the API groups whose resources could not be discovered and so are missing from the
+// backup. A group already recorded by an earlier discovery is not recorded again.
+func (r *Result) addDiscoveryFailures(groups map[schema.GroupVersion]error) {
+	groupVersions := make([]schema.GroupVersion, 0, len(groups))
+	for groupVersion := range groups {
+		groupVersions = append(groupVersions, groupVersion)
+	}
+	sort.Slice(groupVersions, func(i, j int) bool { return groupVersions[i].String() < groupVersions[j].String() })
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, groupVersion := range groupVersions {
+		recorded := false
+		for _, failure := range r.Failures {
+			if failure.Resource == "" && failure.Group == groupVersion.Group && failure.Version == groupVersion.Version {
+				recorded = true
+				break
+			}
+		}
+		if recorded {
+			continue
+		}
+		r.Failures = append(r.Failures, manifest.Failure{
+			Group:   groupVersion.Group,
+			Version: groupVersion.Version,
+			Error:   fmt.Sprintf("error discovering resources: %v", groups[groupVersion]),
+		})
+	}
+}
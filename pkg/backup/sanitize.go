@@ -0,0 +1,14 @@
+package backup
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// sanitizeObject removes the dotted field paths in fields from the object so it can be re-applied to another cluster.
+func sanitizeObject(object *unstructured.Unstructured, fields []string) {
+	for _, field := range fields {
+		unstructured.RemoveNestedField(object.Object, strings.Split(field, ".")...)
+	}
+}
@@ -0,0 +1,41 @@
+package backup
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mattmattox/kubebackup/pkg/metrics"
+)
+
+// runWorkers runs every job using at most workers goroutines and returns once all of them have finished. The
+// number of jobs still waiting for a worker is reported in the work queue depth metric. Jobs that have not started
+// when ctx is canceled are skipped.
+func runWorkers(ctx context.Context, workers int, jobs []func()) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	queue := make(chan func(), len(jobs))
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	metrics.WriteQueueDepth(len(queue))
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(jobs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				metrics.WriteQueueDepth(len(queue))
+				if ctx.Err() != nil {
+					continue
+				}
+				job()
+			}
+		}()
+	}
+
+	wg.Wait()
+}
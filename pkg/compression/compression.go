@@ -0,0 +1,117 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported compression algorithms.
+const (
+	None = "none"
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Validate ensures the algorithm is supported and the level is in its range. A level of 0 selects the
+// algorithm's default.
+func Validate(algorithm string, level int) error {
+	switch algorithm {
+	case None:
+	case Gzip:
+		if level < 0 || level > gzip.BestCompression {
+			return fmt.Errorf("invalid gzip compression level %d: must be between 1 and %d", level, gzip.BestCompression)
+		}
+	case Zstd:
+		if level < 0 || level > 22 {
+			return fmt.Errorf("invalid zstd compression level %d: must be between 1 and 22", level)
+		}
+	default:
+		return fmt.Errorf("invalid compression: %s", algorithm)
+	}
+	return nil
+}
+
+// Extension returns the archive file extension for an algorithm.
+func Extension(algorithm string) string {
+	switch algorithm {
+	case None:
+		return ".tar"
+	case Zstd:
+		return ".tar.zst"
+	default:
+		return ".tar.gz"
+	}
+}
+
+// NewWriter returns a writer that compresses everything written to it into w. Close must be called to flush
+// the compressed stream; it does not close w.
+func NewWriter(w io.Writer, algorithm string, level int) (io.WriteCloser, error) {
+	switch algorithm {
+	case None:
+		return nopWriteCloser{w}, nil
+	case Zstd:
+		options := []zstd.EOption{}
+		if level != 0 {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		zstdWriter, err := zstd.NewWriter(w, options...)
+		if err != nil {
+			return nil, fmt.Errorf("error creating zstd writer: %v", err)
+		}
+		return zstdWriter, nil
+	default:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		gzipWriter, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, fmt.Errorf("error creating gzip writer: %v", err)
+		}
+		return gzipWriter, nil
+	}
+}
+
+// NewReader returns a reader that decompresses r. The algorithm is detected from the stream's magic bytes,
+// and streams that match no known algorithm are read uncompressed.
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	bufReader := bufio.NewReader(r)
+	header, err := bufReader.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading archive header: %v", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		gzipReader, err := gzip.NewReader(bufReader)
+		if err != nil {
+			return nil, fmt.Errorf("error creating gzip reader: %v", err)
+		}
+		return gzipReader, nil
+	case bytes.HasPrefix(header, zstdMagic):
+		zstdReader, err := zstd.NewReader(bufReader)
+		if err != nil {
+			return nil, fmt.Errorf("error creating zstd reader: %v", err)
+		}
+		return zstdReader.IOReadCloser(), nil
+	default:
+		return io.NopCloser(bufReader), nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
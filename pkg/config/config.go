@@ -1,11 +1,13 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // AppConfig structure for environment-based configurations.
@@ -29,11 +31,129 @@ type AppConfig struct {
 	S3DisableSSL      bool   `json:"s3_disable_ssl"`
 	S3CustomCA        string `json:"s3_custom_ca"`
 	S3CustomCAPath    string `json:"s3_custom_ca_path"`
+	Mode              string `json:"mode"`
+	RestoreFile       string `json:"restore_file"`
+
+	Sanitize       bool     `json:"sanitize"`
+	SanitizeFields []string `json:"sanitize_fields"`
+	OutputFormat   string   `json:"output_format"`
+
+	Compression      string `json:"compression"`
+	CompressionLevel int    `json:"compression_level"`
+
+	IncludeNamespaces      []string `json:"include_namespaces"`
+	ExcludeNamespaces      []string `json:"exclude_namespaces"`
+	NamespaceLabelSelector string   `json:"namespace_label_selector"`
+	IncludeResources       []string `json:"include_resources"`
+	ExcludeResources       []string `json:"exclude_resources"`
+
+	BackupStrategy string `json:"backup_strategy"`
+	ListPageSize   int    `json:"list_page_size"`
+	Workers        int    `json:"workers"`
+	KubeAPIQPS     int    `json:"kube_api_qps"`
+	KubeAPIBurst   int    `json:"kube_api_burst"`
+
+	BackupTimeout   time.Duration `json:"backup_timeout"`
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"`
+
+	Targets []TargetConfig `json:"targets"`
+	// TargetsErr is set when BACKUP_TARGETS cannot be parsed
+	TargetsErr error `json:"-"`
+
+	EncryptionRecipients     []string `json:"encryption_recipients"`
+	EncryptionRecipientsFile string   `json:"encryption_recipients_file"`
+	EncryptionPassphrase     string   `json:"encryption_passphrase"`
+	EncryptionPassphraseFile string   `json:"encryption_passphrase_file"`
+	EncryptionIdentityFile   string   `json:"encryption_identity_file"`
+
+	FieldEncryptionKeyFile string   `json:"field_encryption_key_file"`
+	FieldEncryptionFields  []string `json:"field_encryption_fields"`
+
+	Redact            bool     `json:"redact"`
+	RedactFields      []string `json:"redact_fields"`
+	RedactEnvPatterns []string `json:"redact_env_patterns"`
+	RedactSalt        string   `json:"redact_salt"`
+
+	SigningKeyFile       string `json:"signing_key_file"`
+	SigningPublicKeyFile string `json:"signing_public_key_file"`
+
+	VerifyAfterUpload bool   `json:"verify_after_upload"`
+	VerifyTarget      string `json:"verify_target"`
+	VerifyArchive     string `json:"verify_archive"`
+
+	RestoreIncludeNamespaces []string `json:"restore_include_namespaces"`
+	RestoreExcludeNamespaces []string `json:"restore_exclude_namespaces"`
+	RestoreIncludeResources  []string `json:"restore_include_resources"`
+	RestoreExcludeResources  []string `json:"restore_exclude_resources"`
+	RestoreIncludeNames      []string `json:"restore_include_names"`
+	RestoreExcludeNames      []string `json:"restore_exclude_names"`
+	RestoreLabelSelector     string   `json:"restore_label_selector"`
+	RestoreNamespaceMappings []string `json:"restore_namespace_mappings"`
+	RestoreConflictPolicy    string   `json:"restore_conflict_policy"`
+	RestoreReport            string   `json:"restore_report"`
+	RestoreDryRun            bool     `json:"restore_dry_run"`
+	RestoreSignatureFile     string   `json:"restore_signature_file"`
+}
+
+// TargetConfig describes a single storage target backups are uploaded to.
+type TargetConfig struct {
+	Name              string `json:"name"`
+	Type              string `json:"type"`
+	Dir               string `json:"dir"`
+	S3Endpoint        string `json:"s3Endpoint"`
+	S3AccessKeyID     string `json:"s3AccessKeyID"`
+	S3SecretAccessKey string `json:"s3SecretAccessKey"`
+	S3Bucket          string `json:"s3Bucket"`
+	S3Region          string `json:"s3Region"`
+	S3Folder          string `json:"s3_folder"`
+	S3DisableSSL      bool   `json:"s3_disable_ssl"`
+	S3CustomCAPath    string `json:"s3_custom_ca_path"`
 }
 
 // CFG is the global configuration object.
 var CFG AppConfig
 
+// DefaultSanitizeFields are the server-populated fields removed from objects before they are archived.
+var DefaultSanitizeFields = []string{
+	"metadata.uid",
+	"metadata.resourceVersion",
+	"metadata.creationTimestamp",
+	"metadata.managedFields",
+	"metadata.generation",
+	"metadata.selfLink",
+	"status",
+}
+
+// DefaultFieldEncryptionFields are the "<resource>:<field.path>" rules encrypted when field encryption is enabled.
+var DefaultFieldEncryptionFields = []string{
+	"secrets:data",
+	"secrets:stringData",
+}
+
+// DefaultRedactFields are the "<resource>:<field.path>" rules replaced with placeholders when redaction is enabled.
+var DefaultRedactFields = []string{
+	"secrets:data",
+	"secrets:stringData",
+}
+
+// DefaultExcludeResources are the "<resource>[.<group>]" patterns of short-lived or derived resources that are not
+// worth backing up.
+var DefaultExcludeResources = []string{
+	"events",
+	"events.events.k8s.io",
+	"endpointslices.discovery.k8s.io",
+	"leases.coordination.k8s.io",
+	"*.metrics.k8s.io",
+}
+
+// DefaultRedactEnvPatterns are the container env var names whose values are redacted.
+var DefaultRedactEnvPatterns = []string{
+	"*PASSWORD*",
+	"*SECRET*",
+	"*TOKEN*",
+	"*KEY*",
+}
+
 // LoadConfiguration loads configuration from environment variables.
 func LoadConfiguration() {
 	CFG.Debug = parseEnvBool("DEBUG", false)
@@ -48,6 +168,39 @@ func LoadConfiguration() {
 	CFG.S3CustomCA = getEnvOrDefault("S3_CUSTOM_CA", "")
 	CFG.S3CustomCAPath = getEnvOrDefault("S3_CUSTOM_CA_PATH", "")
 	CFG.BackupDir = getEnvOrDefault("BACKUP_DIR", "/pvc")
+	CFG.Sanitize = parseEnvBool("SANITIZE", true)
+	CFG.SanitizeFields = parseEnvList("SANITIZE_FIELDS", DefaultSanitizeFields)
+	CFG.OutputFormat = getEnvOrDefault("OUTPUT_FORMAT", "yaml")
+	CFG.Compression = getEnvOrDefault("COMPRESSION", "gzip")
+	CFG.CompressionLevel = parseEnvInt("COMPRESSION_LEVEL", 0)
+	CFG.IncludeNamespaces = parseEnvList("INCLUDE_NAMESPACES", nil)
+	CFG.ExcludeNamespaces = parseEnvList("EXCLUDE_NAMESPACES", nil)
+	CFG.NamespaceLabelSelector = getEnvOrDefault("NAMESPACE_LABEL_SELECTOR", "")
+	CFG.IncludeResources = parseEnvList("INCLUDE_RESOURCES", nil)
+	CFG.ExcludeResources = parseEnvListOrEmpty("EXCLUDE_RESOURCES", DefaultExcludeResources)
+	CFG.BackupStrategy = getEnvOrDefault("BACKUP_STRATEGY", "namespace")
+	CFG.ListPageSize = parseEnvInt("LIST_PAGE_SIZE", 500)
+	CFG.Workers = parseEnvInt("WORKERS", 10)
+	CFG.KubeAPIQPS = parseEnvInt("KUBE_API_QPS", 20)
+	CFG.KubeAPIBurst = parseEnvInt("KUBE_API_BURST", 40)
+	CFG.BackupTimeout = parseEnvDuration("BACKUP_TIMEOUT", 0)
+	CFG.ShutdownTimeout = parseEnvDuration("SHUTDOWN_TIMEOUT", 25*time.Second)
+	CFG.EncryptionRecipients = parseEnvList("ENCRYPTION_RECIPIENTS", nil)
+	CFG.EncryptionRecipientsFile = getEnvOrDefault("ENCRYPTION_RECIPIENTS_FILE", "")
+	CFG.EncryptionPassphrase = getEnvOrDefault("ENCRYPTION_PASSPHRASE", "")
+	CFG.EncryptionPassphraseFile = getEnvOrDefault("ENCRYPTION_PASSPHRASE_FILE", "")
+	CFG.EncryptionIdentityFile = getEnvOrDefault("ENCRYPTION_IDENTITY_FILE", "")
+	CFG.FieldEncryptionKeyFile = getEnvOrDefault("FIELD_ENCRYPTION_KEY_FILE", "")
+	CFG.FieldEncryptionFields = parseEnvList("FIELD_ENCRYPTION_FIELDS", DefaultFieldEncryptionFields)
+	CFG.Redact = parseEnvBool("REDACT", false)
+	CFG.RedactFields = parseEnvList("REDACT_FIELDS", DefaultRedactFields)
+	CFG.RedactEnvPatterns = parseEnvList("REDACT_ENV_PATTERNS", DefaultRedactEnvPatterns)
+	CFG.RedactSalt = getEnvOrDefault("REDACT_SALT", "")
+	CFG.SigningKeyFile = getEnvOrDefault("SIGNING_KEY_FILE", "")
+	CFG.SigningPublicKeyFile = getEnvOrDefault("SIGNING_PUBLIC_KEY_FILE", "")
+	CFG.VerifyAfterUpload = parseEnvBool("VERIFY_AFTER_UPLOAD", false)
+	CFG.VerifyTarget = getEnvOrDefault("VERIFY_TARGET", "")
+	CFG.VerifyArchive = getEnvOrDefault("VERIFY_ARCHIVE", "")
 	CFG.Retention = parseEnvInt("RETENTION", 30)
 	CFG.CronSchedule = getEnvOrDefault("CRON_SCHEDULE", "0 0 * * *")
 	CFG.DisableCron = parseEnvBool("DISABLE_CRON", false)
@@ -55,6 +208,73 @@ func LoadConfiguration() {
 	CFG.BackupTarget = getEnvOrDefault("BACKUP_TARGET", "s3")
 	CFG.Kubeconfig = getEnvOrDefault("KUBECONFIG", "~/.kube/config")
 	CFG.LogLevel = getEnvOrDefault("LOG_LEVEL", "info")
+	CFG.Targets, CFG.TargetsErr = loadTargets()
+	CFG.Mode = getEnvOrDefault("MODE", "backup")
+	CFG.RestoreFile = getEnvOrDefault("RESTORE_FILE", "")
+	CFG.RestoreIncludeNamespaces = parseEnvList("RESTORE_INCLUDE_NAMESPACES", nil)
+	CFG.RestoreExcludeNamespaces = parseEnvList("RESTORE_EXCLUDE_NAMESPACES", nil)
+	CFG.RestoreIncludeResources = parseEnvList("RESTORE_INCLUDE_RESOURCES", nil)
+	CFG.RestoreExcludeResources = parseEnvList("RESTORE_EXCLUDE_RESOURCES", nil)
+	CFG.RestoreIncludeNames = parseEnvList("RESTORE_INCLUDE_NAMES", nil)
+	CFG.RestoreExcludeNames = parseEnvList("RESTORE_EXCLUDE_NAMES", nil)
+	CFG.RestoreLabelSelector = getEnvOrDefault("RESTORE_LABEL_SELECTOR", "")
+	CFG.RestoreNamespaceMappings = parseEnvList("RESTORE_NAMESPACE_MAPPINGS", nil)
+	CFG.RestoreConflictPolicy = getEnvOrDefault("RESTORE_CONFLICT_POLICY", "skip")
+	CFG.RestoreReport = getEnvOrDefault("RESTORE_REPORT", "/tmp/kubebackup-restore-report.json")
+	CFG.RestoreDryRun = parseEnvBool("RESTORE_DRY_RUN", false)
+	CFG.RestoreSignatureFile = getEnvOrDefault("RESTORE_SIGNATURE_FILE", "")
+}
+
+// loadTargets reads the storage targets from BACKUP_TARGETS as a JSON list, rejecting unknown keys. When it is not
+// set, targets are built from the comma-separated BACKUP_TARGET types and the S3_* and BACKUP_DIR settings.
+func loadTargets() ([]TargetConfig, error) {
+	var targets []TargetConfig
+	if value := os.Getenv("BACKUP_TARGETS"); value != "" {
+		decoder := json.NewDecoder(strings.NewReader(value))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&targets); err != nil {
+			return nil, fmt.Errorf("error parsing BACKUP_TARGETS: %v", err)
+		}
+	} else {
+		for _, targetType := range strings.Split(CFG.BackupTarget, ",") {
+			targetType = strings.TrimSpace(targetType)
+			if targetType == "" {
+				continue
+			}
+			target := TargetConfig{Type: targetType}
+			switch targetType {
+			case "s3":
+				caPath := CFG.S3CustomCAPath
+				if caPath == "" {
+					caPath = CFG.S3CustomCA
+				}
+				target.S3Endpoint = CFG.S3Endpoint
+				target.S3AccessKeyID = CFG.S3AccessKeyID
+				target.S3SecretAccessKey = CFG.S3SecretAccessKey
+				target.S3Bucket = CFG.S3Bucket
+				target.S3Region = CFG.S3Region
+				target.S3Folder = CFG.S3Folder
+				target.S3DisableSSL = CFG.S3DisableSSL
+				target.S3CustomCAPath = caPath
+			case "local":
+				target.Dir = CFG.BackupDir
+			}
+			targets = append(targets, target)
+		}
+	}
+
+	// Default target names to their type, keeping them unique
+	names := make(map[string]bool, len(targets))
+	for i := range targets {
+		if targets[i].Name == "" {
+			targets[i].Name = targets[i].Type
+			if names[targets[i].Name] {
+				targets[i].Name = fmt.Sprintf("%s-%d", targets[i].Type, i)
+			}
+		}
+		names[targets[i].Name] = true
+	}
+	return targets, nil
 }
 
 func getEnvOrDefault(key, defaultValue string) string {
@@ -79,6 +299,42 @@ func parseEnvInt(key string, defaultValue int) int {
 	return intValue
 }
 
+func parseEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Failed to parse environment variable %s: %v. Using default value: %v", key, err, defaultValue)
+		return defaultValue
+	}
+	return duration
+}
+
+func parseEnvList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseEnvListOrEmpty is like parseEnvList, but a variable that is set to an empty value yields an empty list
+// instead of the default.
+func parseEnvListOrEmpty(key string, defaultValue []string) []string {
+	if value, exists := os.LookupEnv(key); exists && strings.TrimSpace(value) == "" {
+		return nil
+	}
+	return parseEnvList(key, defaultValue)
+}
+
 func parseEnvBool(key string, defaultValue bool) bool {
 	value, exists := os.LookupEnv(key)
 	if !exists {
@@ -0,0 +1,179 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/mattmattox/kubebackup/pkg/config"
+)
+
+// Extension is appended to the file name of encrypted archives.
+const Extension = ".age"
+
+// ageHeader is the first line of every age-encrypted stream.
+var ageHeader = []byte("age-encryption.org/v1\n")
+
+// Enabled reports whether archives should be encrypted.
+func Enabled(cfg *config.AppConfig) bool {
+	return len(cfg.EncryptionRecipients) > 0 || cfg.EncryptionRecipientsFile != "" || cfg.EncryptionPassphrase != "" || cfg.EncryptionPassphraseFile != ""
+}
+
+// Validate ensures the encryption settings can be used to encrypt and decrypt archives.
+func Validate(cfg *config.AppConfig) error {
+	if Enabled(cfg) {
+		if _, err := recipients(cfg); err != nil {
+			return err
+		}
+	}
+	if cfg.EncryptionIdentityFile != "" {
+		if _, err := identities(cfg); err != nil {
+			return err
+		}
+	}
+
+	if _, err := NewFieldEncrypter(cfg); err != nil {
+		return err
+	}
+	return nil
+}
+
+// NewWriter returns a writer that encrypts everything written to it for the configured recipients
+// or passphrase. Close must be called to flush the final chunk; it does not close w.
+func NewWriter(w io.Writer, cfg *config.AppConfig) (io.WriteCloser, error) {
+	ageRecipients, err := recipients(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	encWriter, err := age.Encrypt(w, ageRecipients...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating encrypted writer: %v", err)
+	}
+	return encWriter, nil
+}
+
+// ErrNoIdentity is returned by NewReader for an encrypted stream when no identity or passphrase is configured.
+var ErrNoIdentity = errors.New("archive is encrypted but no identity file or passphrase is configured")
+
+// NewReader returns a reader that decrypts r when it is an age-encrypted stream.
+// Unencrypted streams are returned unchanged.
+func NewReader(r io.Reader, cfg *config.AppConfig) (io.Reader, error) {
+	bufReader := bufio.NewReader(r)
+	header, err := bufReader.Peek(len(ageHeader))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading archive header: %v", err)
+	}
+	if !bytes.Equal(header, ageHeader) {
+		return bufReader, nil
+	}
+
+	ageIdentities, err := identities(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(ageIdentities) == 0 {
+		return nil, ErrNoIdentity
+	}
+
+	decReader, err := age.Decrypt(bufReader, ageIdentities...)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting archive: %v", err)
+	}
+	return decReader, nil
+}
+
+// recipients builds the age recipients from the configured public keys or passphrase.
+func recipients(cfg *config.AppConfig) ([]age.Recipient, error) {
+	passphrase, err := passphrase(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var ageRecipients []age.Recipient
+	for _, key := range cfg.EncryptionRecipients {
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption recipient '%s': %v", key, err)
+		}
+		ageRecipients = append(ageRecipients, recipient)
+	}
+
+	if cfg.EncryptionRecipientsFile != "" {
+		file, err := os.Open(cfg.EncryptionRecipientsFile)
+		if err != nil {
+			return nil, fmt.Errorf("error opening recipients file: %v", err)
+		}
+		defer file.Close()
+
+		fileRecipients, err := age.ParseRecipients(file)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing recipients file: %v", err)
+		}
+		ageRecipients = append(ageRecipients, fileRecipients...)
+	}
+
+	if passphrase != "" {
+		if len(ageRecipients) > 0 {
+			return nil, fmt.Errorf("an encryption passphrase cannot be combined with recipient public keys")
+		}
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("error creating passphrase recipient: %v", err)
+		}
+		ageRecipients = append(ageRecipients, recipient)
+	}
+
+	if len(ageRecipients) == 0 {
+		return nil, fmt.Errorf("no encryption recipients or passphrase configured")
+	}
+	return ageRecipients, nil
+}
+
+// identities builds the age identities used to decrypt archives from the identity file and passphrase.
+func identities(cfg *config.AppConfig) ([]age.Identity, error) {
+	passphrase, err := passphrase(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var ageIdentities []age.Identity
+	if cfg.EncryptionIdentityFile != "" {
+		file, err := os.Open(cfg.EncryptionIdentityFile)
+		if err != nil {
+			return nil, fmt.Errorf("error opening identity file: %v", err)
+		}
+		defer file.Close()
+
+		ageIdentities, err = age.ParseIdentities(file)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing identity file: %v", err)
+		}
+	}
+
+	if passphrase != "" {
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("error creating passphrase identity: %v", err)
+		}
+		ageIdentities = append(ageIdentities, identity)
+	}
+	return ageIdentities, nil
+}
+
+// passphrase returns the configured passphrase, reading it from the passphrase file if set.
+func passphrase(cfg *config.AppConfig) (string, error) {
+	if cfg.EncryptionPassphraseFile == "" {
+		return cfg.EncryptionPassphrase, nil
+	}
+	data, err := os.ReadFile(cfg.EncryptionPassphraseFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading passphrase file: %v", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
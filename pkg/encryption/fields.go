@@ -0,0 +1,240 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/mattmattox/kubebackup/pkg/config"
+	"github.com/mattmattox/kubebackup/pkg/fieldpath"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// EncryptedFieldsAnnotation lists the field paths that were encrypted in an archived object.
+const EncryptedFieldsAnnotation = "kubebackup.io/encrypted-fields"
+
+const (
+	fieldCipher  = "AES256_GCM"
+	fieldKeySize = 32
+)
+
+// encryptedValue matches values written by encryptValue, e.g. ENC[AES256_GCM,data:...,iv:...,tag:...,type:str].
+var encryptedValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:([^,]*),iv:([^,]+),tag:([^,]+),type:(str|json)\]$`)
+
+// KeyProvider supplies the data key used for field-level encryption.
+type KeyProvider interface {
+	DataKey() ([]byte, error)
+}
+
+// LocalKeyProvider reads a 256-bit data key from a file. The file may hold the raw key or its base64 or hex encoding.
+type LocalKeyProvider struct {
+	Path string
+}
+
+// DataKey reads and decodes the key file.
+func (p *LocalKeyProvider) DataKey() ([]byte, error) {
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading field encryption key: %v", err)
+	}
+	if len(data) == fieldKeySize {
+		return data, nil
+	}
+
+	encoded := strings.TrimSpace(string(data))
+	if key, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(key) == fieldKeySize {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) == fieldKeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("field encryption key in %s must be %d bytes, raw or base64/hex encoded", p.Path, fieldKeySize)
+}
+
+// FieldEncrypter encrypts selected fields of objects while leaving the rest of the object readable.
+// It is safe for concurrent use.
+type FieldEncrypter struct {
+	gcm   cipher.AEAD
+	rules []fieldpath.Rule
+}
+
+// NewFieldEncrypter loads the configured key and returns a FieldEncrypter for it and the configured rules,
+// or nil if field encryption is disabled.
+func NewFieldEncrypter(cfg *config.AppConfig) (*FieldEncrypter, error) {
+	if cfg.FieldEncryptionKeyFile == "" {
+		return nil, nil
+	}
+	rules, err := fieldpath.ParseRules(cfg.FieldEncryptionFields)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newCipher(&LocalKeyProvider{Path: cfg.FieldEncryptionKeyFile})
+	if err != nil {
+		return nil, err
+	}
+	return &FieldEncrypter{
+		gcm:   gcm,
+		rules: rules,
+	}, nil
+}
+
+// EncryptObject encrypts the fields selected for the object's resource in place and records the rules that
+// encrypted at least one value in the EncryptedFieldsAnnotation. The last-applied-configuration annotation is
+// dropped since it holds the plaintext.
+func (e *FieldEncrypter) EncryptObject(object *unstructured.Unstructured, resource schema.GroupVersionResource) error {
+	var paths []string
+	for _, rule := range e.rules {
+		if !rule.Matches(resource) {
+			continue
+		}
+		encrypted := false
+		err := fieldpath.Transform(object.Object, rule.Path, func(value interface{}, valuePath string) (interface{}, error) {
+			encrypted = true
+			return encryptValue(e.gcm, value, valuePath)
+		})
+		if err != nil {
+			return fmt.Errorf("error encrypting field '%s': %v", rule.String(), err)
+		}
+		if encrypted {
+			paths = append(paths, rule.String())
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	annotations := object.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	delete(annotations, fieldpath.LastAppliedAnnotation)
+	annotations[EncryptedFieldsAnnotation] = strings.Join(paths, ",")
+	object.SetAnnotations(annotations)
+	return nil
+}
+
+// DecryptObject decrypts the fields listed in the object's EncryptedFieldsAnnotation in place and removes the annotation.
+func (e *FieldEncrypter) DecryptObject(object *unstructured.Unstructured) error {
+	annotations := object.GetAnnotations()
+	paths, ok := annotations[EncryptedFieldsAnnotation]
+	if !ok {
+		return nil
+	}
+
+	for _, fieldPath := range strings.Split(paths, ",") {
+		err := fieldpath.Transform(object.Object, strings.Split(fieldPath, "."), func(value interface{}, valuePath string) (interface{}, error) {
+			return decryptValue(e.gcm, value, valuePath)
+		})
+		if err != nil {
+			return fmt.Errorf("error decrypting field '%s': %v", fieldPath, err)
+		}
+	}
+
+	delete(annotations, EncryptedFieldsAnnotation)
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	object.SetAnnotations(annotations)
+	return nil
+}
+
+// HasEncryptedFields reports whether an object holds fields encrypted by a FieldEncrypter.
+func HasEncryptedFields(object *unstructured.Unstructured) bool {
+	_, ok := object.GetAnnotations()[EncryptedFieldsAnnotation]
+	return ok
+}
+
+func newCipher(provider KeyProvider) (cipher.AEAD, error) {
+	key, err := provider.DataKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptValue encrypts a scalar value, authenticating it against its field path so values cannot be moved between fields.
+func encryptValue(gcm cipher.AEAD, value interface{}, valuePath string) (interface{}, error) {
+	valueType := "str"
+	plaintext, ok := value.(string)
+	if !ok {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		valueType = "json"
+		plaintext = string(data)
+	}
+
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, fmt.Errorf("error generating IV: %v", err)
+	}
+	sealed := gcm.Seal(nil, iv, []byte(plaintext), []byte(valuePath))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	return fmt.Sprintf("ENC[%s,data:%s,iv:%s,tag:%s,type:%s]", fieldCipher,
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag),
+		valueType), nil
+}
+
+// decryptValue reverses encryptValue. Values that are not encrypted are returned unchanged.
+func decryptValue(gcm cipher.AEAD, value interface{}, valuePath string) (interface{}, error) {
+	encrypted, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	match := encryptedValue.FindStringSubmatch(encrypted)
+	if match == nil {
+		return value, nil
+	}
+
+	var parts [3][]byte
+	for i := range parts {
+		decoded, err := base64.StdEncoding.DecodeString(match[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("malformed encrypted value at '%s': %v", valuePath, err)
+		}
+		parts[i] = decoded
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+	if len(iv) != gcm.NonceSize() {
+		return nil, fmt.Errorf("malformed encrypted value at '%s': invalid IV", valuePath)
+	}
+
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(valuePath))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting value at '%s': %v", valuePath, err)
+	}
+
+	if match[4] == "json" {
+		decoder := json.NewDecoder(strings.NewReader(string(plaintext)))
+		decoder.UseNumber()
+		var decoded interface{}
+		if err := decoder.Decode(&decoded); err != nil {
+			return nil, fmt.Errorf("error decoding value at '%s': %v", valuePath, err)
+		}
+		// Unstructured objects hold integers as int64 and other numbers as float64
+		if number, ok := decoded.(json.Number); ok {
+			if i, err := number.Int64(); err == nil {
+				return i, nil
+			}
+			return number.Float64()
+		}
+		return decoded, nil
+	}
+	return string(plaintext), nil
+}
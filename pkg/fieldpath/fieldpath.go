@@ -0,0 +1,126 @@
+package fieldpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mattmattox/kubebackup/pkg/filter"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Rule selects a dotted field path in objects of the resources matching a glob.
+type Rule struct {
+	Resource string
+	Path     []string
+}
+
+// ParseRules parses entries of the form "<resource>[.<group>]:<dotted.path>".
+func ParseRules(entries []string) ([]Rule, error) {
+	rules := make([]Rule, 0, len(entries))
+	for _, entry := range entries {
+		resource, fieldPath, ok := strings.Cut(entry, ":")
+		if !ok || resource == "" || fieldPath == "" {
+			return nil, fmt.Errorf("invalid field rule '%s': expected <resource>:<field.path>", entry)
+		}
+		if err := filter.ValidatePatterns([]string{resource}); err != nil {
+			return nil, fmt.Errorf("invalid field rule '%s': %v", entry, err)
+		}
+		rules = append(rules, Rule{Resource: resource, Path: strings.Split(fieldPath, ".")})
+	}
+	return rules, nil
+}
+
+// Matches reports whether the rule applies to a resource, matched as "resource.group", or as bare "resource" for
+// the core group only, so a rule for secrets does not apply to a secrets resource of another API group.
+func (r Rule) Matches(resource schema.GroupVersionResource) bool {
+	return filter.MatchAny([]string{r.Resource}, resource.GroupResource().String())
+}
+
+// String returns the dotted field path of the rule.
+func (r Rule) String() string {
+	return strings.Join(r.Path, ".")
+}
+
+// TransformFunc returns the replacement for a scalar value found at valuePath.
+type TransformFunc func(value interface{}, valuePath string) (interface{}, error)
+
+// Transform applies fn to every scalar value at fieldPath. Lists along the path are traversed element by element,
+// and maps or lists found at the end of the path have all of their scalar values transformed.
+func Transform(object map[string]interface{}, fieldPath []string, fn TransformFunc) error {
+	return transformField(object, fieldPath, "", fn)
+}
+
+func transformField(object map[string]interface{}, fieldPath []string, valuePath string, fn TransformFunc) error {
+	value, ok := object[fieldPath[0]]
+	if !ok {
+		return nil
+	}
+	valuePath = joinPath(valuePath, fieldPath[0])
+
+	if len(fieldPath) == 1 {
+		transformed, err := TransformValue(value, valuePath, fn)
+		if err != nil {
+			return err
+		}
+		object[fieldPath[0]] = transformed
+		return nil
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return transformField(v, fieldPath[1:], valuePath, fn)
+	case []interface{}:
+		for i, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				if err := transformField(m, fieldPath[1:], indexPath(valuePath, i), fn); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// TransformValue applies fn to a scalar value or, for maps and lists, to every scalar it contains.
+func TransformValue(value interface{}, valuePath string, fn TransformFunc) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		for key, item := range v {
+			transformed, err := TransformValue(item, joinPath(valuePath, key), fn)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = transformed
+		}
+		return v, nil
+	case []interface{}:
+		for i, item := range v {
+			transformed, err := TransformValue(item, indexPath(valuePath, i), fn)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = transformed
+		}
+		return v, nil
+	default:
+		return fn(v, valuePath)
+	}
+}
+
+func joinPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+func indexPath(parent string, i int) string {
+	return parent + "[" + strconv.Itoa(i) + "]"
+}
+
+// LastAppliedAnnotation is set by kubectl apply and holds a full copy of the applied object,
+// including values that are encrypted or redacted elsewhere in it.
+const LastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
@@ -0,0 +1,35 @@
+package filter
+
+import (
+	"fmt"
+	"path"
+)
+
+// MatchAny reports whether value matches any of the glob patterns.
+func MatchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether value passes an include/exclude pair of glob lists.
+// An empty include list allows everything that is not excluded.
+func Allowed(includes, excludes []string, value string) bool {
+	if len(includes) > 0 && !MatchAny(includes, value) {
+		return false
+	}
+	return !MatchAny(excludes, value)
+}
+
+// ValidatePatterns returns an error for the first malformed glob pattern.
+func ValidatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %v", pattern, err)
+		}
+	}
+	return nil
+}
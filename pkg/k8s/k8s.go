@@ -6,20 +6,27 @@ import (
 	"os"
 
 	"github.com/mattmattox/kubebackup/pkg/logging"
+	"github.com/mattmattox/kubebackup/pkg/metrics"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientmetrics "k8s.io/client-go/tools/metrics"
+	"k8s.io/client-go/util/flowcontrol"
 )
 
 var log = logging.SetupLogging()
 
-// ConnectToCluster connects to the Kubernetes cluster and returns both *kubernetes.Clientset and dynamic.Interface
-func ConnectToCluster(kubeconfig string) (*kubernetes.Clientset, dynamic.Interface, error) {
+// ConnectToCluster connects to the Kubernetes cluster and returns both *kubernetes.Clientset and dynamic.Interface.
+// Both clients share a single client-side rate limiter allowing qps requests per second with bursts of burst.
+func ConnectToCluster(kubeconfig string, qps, burst int) (*kubernetes.Clientset, dynamic.Interface, error) {
 	var config *rest.Config
 	var err error
 
@@ -43,6 +50,12 @@ func ConnectToCluster(kubeconfig string) (*kubernetes.Clientset, dynamic.Interfa
 		}
 	}
 
+	// Share one rate limiter between the clients and report the time requests spend waiting on it
+	config.QPS = float32(qps)
+	config.Burst = burst
+	config.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(config.QPS, config.Burst)
+	clientmetrics.Register(clientmetrics.RegisterOpts{RateLimiterLatency: metrics.ThrottleWaitMetric{}})
+
 	// Create the *kubernetes.Clientset
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -59,9 +72,8 @@ func ConnectToCluster(kubeconfig string) (*kubernetes.Clientset, dynamic.Interfa
 }
 
 // VerifyAccessToCluster verifies the connection to the Kubernetes cluster by listing nodes.
-func VerifyAccessToCluster(clientset *kubernetes.Clientset) error {
+func VerifyAccessToCluster(ctx context.Context, clientset *kubernetes.Clientset) error {
 	log.Infoln("Verifying access to the Kubernetes cluster...")
-	ctx := context.TODO()
 	listOptions := v1.ListOptions{}
 
 	_, err := clientset.CoreV1().Nodes().List(ctx, listOptions)
@@ -73,8 +85,9 @@ func VerifyAccessToCluster(clientset *kubernetes.Clientset) error {
 	return nil
 }
 
-func GetNamespaces(clientset *kubernetes.Clientset) ([]string, error) {
-	namespaceList, err := clientset.CoreV1().Namespaces().List(context.Background(), v1.ListOptions{})
+// GetNamespaces returns the names of the namespaces matching labelSelector, or of every namespace if it is empty.
+func GetNamespaces(ctx context.Context, clientset *kubernetes.Clientset, labelSelector string) ([]string, error) {
+	namespaceList, err := clientset.CoreV1().Namespaces().List(ctx, v1.ListOptions{LabelSelector: labelSelector})
 	if err != nil {
 		return nil, err
 	}
@@ -85,24 +98,21 @@ func GetNamespaces(clientset *kubernetes.Clientset) ([]string, error) {
 	return namespaces, nil
 }
 
-// GetNamespaceScopedResources returns a list of namespaced resources as []schema.GroupVersionResource
-func GetNamespaceScopedResources(clientset *kubernetes.Clientset) ([]schema.GroupVersionResource, error) {
+// GetNamespaceScopedResources returns a list of namespaced resources as []schema.GroupVersionResource, along with
+// the API groups whose resources could not be discovered.
+func GetNamespaceScopedResources(clientset *kubernetes.Clientset) ([]schema.GroupVersionResource, map[schema.GroupVersion]error, error) {
 	discoveryClient := clientset.Discovery()
 	apiResourceLists, err := discoveryClient.ServerPreferredNamespacedResources()
+	failedGroups, err := discoveryFailures(err)
 	if err != nil {
-		if discovery.IsGroupDiscoveryFailedError(err) {
-			// Handle partial discovery errors
-			fmt.Printf("Partial discovery error: %v\n", err)
-		} else {
-			return nil, fmt.Errorf("error fetching namespaced resources: %v", err)
-		}
+		return nil, nil, fmt.Errorf("error fetching namespaced resources: %v", err)
 	}
 
 	var resources []schema.GroupVersionResource
 	for _, apiResourceList := range apiResourceLists {
 		groupVersion, err := schema.ParseGroupVersion(apiResourceList.GroupVersion)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing GroupVersion %s: %v", apiResourceList.GroupVersion, err)
+			return nil, nil, fmt.Errorf("error parsing GroupVersion %s: %v", apiResourceList.GroupVersion, err)
 		}
 		for _, apiResource := range apiResourceList.APIResources {
 			if apiResource.Namespaced {
@@ -111,26 +121,24 @@ func GetNamespaceScopedResources(clientset *kubernetes.Clientset) ([]schema.Grou
 		}
 	}
 
-	return resources, nil
+	return resources, failedGroups, nil
 }
 
-// GetClusterScopedResources fetches all cluster-scoped resources
-func GetClusterScopedResources(clientset *kubernetes.Clientset) ([]schema.GroupVersionResource, error) {
+// GetClusterScopedResources fetches all cluster-scoped resources, along with the API groups whose resources could
+// not be discovered.
+func GetClusterScopedResources(clientset *kubernetes.Clientset) ([]schema.GroupVersionResource, map[schema.GroupVersion]error, error) {
 	discoveryClient := clientset.Discovery()
 	apiResourceLists, err := discoveryClient.ServerPreferredResources()
+	failedGroups, err := discoveryFailures(err)
 	if err != nil {
-		if discovery.IsGroupDiscoveryFailedError(err) {
-			fmt.Printf("Partial discovery error: %v\n", err)
-		} else {
-			return nil, fmt.Errorf("error fetching cluster-scoped resources: %v", err)
-		}
+		return nil, nil, fmt.Errorf("error fetching cluster-scoped resources: %v", err)
 	}
 
 	var resources []schema.GroupVersionResource
 	for _, apiResourceList := range apiResourceLists {
 		groupVersion, err := schema.ParseGroupVersion(apiResourceList.GroupVersion)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing GroupVersion %s: %v", apiResourceList.GroupVersion, err)
+			return nil, nil, fmt.Errorf("error parsing GroupVersion %s: %v", apiResourceList.GroupVersion, err)
 		}
 
 		for _, apiResource := range apiResourceList.APIResources {
@@ -140,7 +148,23 @@ func GetClusterScopedResources(clientset *kubernetes.Clientset) ([]schema.GroupV
 		}
 	}
 
-	return resources, nil
+	return resources, failedGroups, nil
+}
+
+// discoveryFailures splits a discovery error into the API groups that failed, when discovery was only partial, and
+// an error that should stop the caller.
+func discoveryFailures(err error) (map[schema.GroupVersion]error, error) {
+	if err == nil {
+		return nil, nil
+	}
+	groupErr, ok := err.(*discovery.ErrGroupDiscoveryFailed)
+	if !ok {
+		return nil, err
+	}
+	for groupVersion, groupErr := range groupErr.Groups {
+		log.Warnf("Partial discovery error: unable to retrieve resources of %s: %v", groupVersion, groupErr)
+	}
+	return groupErr.Groups, nil
 }
 
 func GetNamespacedObjects(clientset *kubernetes.Clientset) ([]schema.GroupVersionResource, error) {
@@ -168,21 +192,75 @@ func GetNamespacedObjects(clientset *kubernetes.Clientset) ([]schema.GroupVersio
 	return objects, nil
 }
 
-// GetNamespaceObjects retrieves the list of object names for a specific resource in a namespace.
-func GetNamespaceObjects(dynamicClient dynamic.Interface, ns string, resource schema.GroupVersionResource, apiVersion string) ([]string, error) {
-	// List the objects for the given resource
-	resourceList, err := dynamicClient.Resource(resource).Namespace(ns).List(context.TODO(), v1.ListOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("error listing objects for resource %s in namespace %s: %v", resource.Resource, ns, err)
-	}
+// maxListRestarts bounds how often ListObjects starts a list over after its continue token expired.
+const maxListRestarts = 3
 
-	// Extract the names of the objects
-	objectNames := make([]string, len(resourceList.Items))
-	for i, obj := range resourceList.Items {
-		objectNames[i] = obj.GetName()
+// ListObjects lists the objects of a resource in pages of at most pageSize items and calls fn for each of them,
+// so large lists are never held in memory at once. An empty namespace lists the resource in every namespace, and
+// a pageSize of 0 fetches everything in a single request.
+//
+// If the continue token expires between pages, the list resumes from the token returned with the expiry, or
+// starts over when there is none, and objects that were already passed to fn are skipped. Lists are sorted by
+// namespace and name, so objects from before the first restart are skipped by their position and only objects
+// passed to fn after it are tracked by UID.
+func ListObjects(ctx context.Context, dynamicClient dynamic.Interface, ns string, resource schema.GroupVersionResource, pageSize int, fn func(*unstructured.Unstructured) error) error {
+	listOptions := v1.ListOptions{Limit: int64(pageSize)}
+	var seen map[types.UID]bool
+	var lastKey, skipThrough string
+	restarts := 0
+	for {
+		resourceList, err := dynamicClient.Resource(resource).Namespace(ns).List(ctx, listOptions)
+		if apierrors.IsResourceExpired(err) && listOptions.Continue != "" && restarts < maxListRestarts {
+			if restarts == 0 {
+				seen = make(map[types.UID]bool)
+				skipThrough = lastKey
+			}
+			restarts++
+			listOptions.Continue = ""
+			if status, ok := err.(apierrors.APIStatus); ok {
+				listOptions.Continue = status.Status().Continue
+			}
+			log.Warnf("List of resource %s in namespace %s expired, resuming (%d of %d)", resource.Resource, ns, restarts, maxListRestarts)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error listing objects for resource %s in namespace %s: %v", resource.Resource, ns, err)
+		}
+
+		for i := range resourceList.Items {
+			object := &resourceList.Items[i]
+			if restarts > 0 {
+				if skipThrough != "" && objectKey(object) <= skipThrough {
+					continue
+				}
+				if uid := object.GetUID(); uid != "" {
+					if seen[uid] {
+						continue
+					}
+					seen[uid] = true
+				}
+			}
+			if err := fn(object); err != nil {
+				return err
+			}
+		}
+
+		if restarts == 0 && len(resourceList.Items) > 0 {
+			lastKey = objectKey(&resourceList.Items[len(resourceList.Items)-1])
+		}
+		listOptions.Continue = resourceList.GetContinue()
+		if listOptions.Continue == "" {
+			return nil
+		}
 	}
+}
 
-	return objectNames, nil
+// objectKey returns the key a list is sorted by.
+func objectKey(object *unstructured.Unstructured) string {
+	if object.GetNamespace() == "" {
+		return object.GetName()
+	}
+	return object.GetNamespace() + "/" + object.GetName()
 }
 
 func GetAPIVersionForResource(clientset *kubernetes.Clientset, resource schema.GroupVersionResource) (string, error) {
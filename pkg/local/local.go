@@ -0,0 +1,164 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mattmattox/kubebackup/pkg/config"
+	"github.com/mattmattox/kubebackup/pkg/logging"
+	"github.com/mattmattox/kubebackup/pkg/storage"
+)
+
+var log = logging.SetupLogging()
+
+// Target stores backups in a local directory, such as a mounted PVC.
+type Target struct {
+	name string
+	dir  string
+}
+
+// tempMarker is part of the name of every temporary file an upload writes to.
+const tempMarker = ".tmp-"
+
+// staleTempAge is how long a temporary file must go unwritten before it is treated as left behind by an upload
+// that was interrupted, for example by the pod being killed.
+const staleTempAge = time.Hour
+
+// NewTarget creates a local target for the directory in cfg and removes stale temporary files left in it.
+func NewTarget(cfg config.TargetConfig) (*Target, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("local target %s is missing a directory", cfg.Name)
+	}
+	if err := os.MkdirAll(cfg.Dir, 0750); err != nil {
+		return nil, fmt.Errorf("error creating backup directory '%s': %v", cfg.Dir, err)
+	}
+	t := &Target{name: cfg.Name, dir: cfg.Dir}
+	if err := t.removeStaleTempFiles(); err != nil {
+		log.Warnf("Unable to remove stale temporary files in %s: %v", cfg.Dir, err)
+	}
+	return t, nil
+}
+
+// removeStaleTempFiles deletes temporary files of uploads that never completed. Files still being written by a
+// running upload, in this or another process sharing the directory, are left alone.
+func (t *Target) removeStaleTempFiles() error {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !isTempFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < staleTempAge {
+			continue
+		}
+		log.Infof("Removing stale temporary file: %s", entry.Name())
+		if err := os.Remove(filepath.Join(t.dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isTempFile reports whether name is a temporary file written by Upload.
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, tempMarker)
+}
+
+// Name returns the name of the target.
+func (t *Target) Name() string {
+	return t.name
+}
+
+// Upload writes body to a temporary file next to the destination, syncs it and renames it
+// into place, so a partially written backup never appears under its final name. If ctx is
+// canceled the temporary file is removed and nothing is stored.
+func (t *Target) Upload(ctx context.Context, key string, body io.Reader) error {
+	destPath := filepath.Join(t.dir, filepath.Base(key))
+	log.Infof("Saving file: %s", destPath)
+
+	tmpFile, err := os.CreateTemp(t.dir, "."+filepath.Base(key)+tempMarker)
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := io.Copy(tmpFile, &contextReader{ctx: ctx, reader: body}); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("error writing file: %v", err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("error syncing file: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("error closing file: %v", err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), destPath); err != nil {
+		return fmt.Errorf("error renaming file: %v", err)
+	}
+	return nil
+}
+
+// List returns the files stored in the backup directory, leaving out the temporary files of uploads.
+func (t *Target) List(ctx context.Context) ([]storage.Object, error) {
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing backup directory: %v", err)
+	}
+
+	objects := make([]storage.Object, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || isTempFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("error reading file info: %v", err)
+		}
+		objects = append(objects, storage.Object{
+			Key:          entry.Name(),
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+	}
+	return objects, nil
+}
+
+// Download opens the file stored under key.
+func (t *Target) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(t.dir, filepath.Base(key)))
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %v", err)
+	}
+	return file, nil
+}
+
+// Delete removes the file stored under key.
+func (t *Target) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(filepath.Join(t.dir, filepath.Base(key))); err != nil {
+		return fmt.Errorf("error deleting file: %v", err)
+	}
+	return nil
+}
+
+// contextReader stops reading once its context is canceled.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
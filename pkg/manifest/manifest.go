@@ -0,0 +1,148 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// FileName is the name of the manifest at the root of every archive.
+const FileName = "manifest.json"
+
+// ChecksumExtension is appended to an archive key for its checksum sidecar.
+const ChecksumExtension = ".sha256"
+
+// FormatVersion is the version of the manifest format.
+const FormatVersion = 1
+
+// Entry describes a single object file in an archive.
+type Entry struct {
+	Path      string `json:"path"`
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	SHA256    string `json:"sha256"`
+}
+
+// Failure describes a resource or object that could not be backed up. Name is empty when the whole resource could
+// not be listed, and Resource is empty when none of the resources of an API group version could be discovered.
+type Failure struct {
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Error     string `json:"error"`
+}
+
+// Manifest lists every object file in an archive so its completeness and integrity can be verified. A partial
+// archive is missing the resources and objects listed in Failures.
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Objects   []Entry   `json:"objects"`
+	Partial   bool      `json:"partial,omitempty"`
+	Failures  []Failure `json:"failures,omitempty"`
+}
+
+// New returns an empty manifest.
+func New() *Manifest {
+	return &Manifest{Version: FormatVersion, CreatedAt: time.Now().UTC(), Objects: []Entry{}}
+}
+
+// Add appends an entry to the manifest.
+func (m *Manifest) Add(entry Entry) {
+	m.Objects = append(m.Objects, entry)
+}
+
+// AddFailure records a resource or object that is missing from the archive and marks the archive as partial.
+func (m *Manifest) AddFailure(failure Failure) {
+	m.Failures = append(m.Failures, failure)
+	m.Partial = true
+}
+
+// NewEntry returns the manifest entry for the object file data of the named object, stored at archivePath.
+func NewEntry(archivePath string, resource schema.GroupVersionResource, namespace, name string, data []byte) Entry {
+	sum := sha256.Sum256(data)
+	return Entry{
+		Path:      archivePath,
+		Group:     resource.Group,
+		Version:   resource.Version,
+		Resource:  resource.Resource,
+		Namespace: namespace,
+		Name:      name,
+		SHA256:    hex.EncodeToString(sum[:]),
+	}
+}
+
+// Encode returns the manifest as indented JSON.
+func (m *Manifest) Encode() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding manifest: %v", err)
+	}
+	return data, nil
+}
+
+// Read decodes a manifest.
+func Read(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, fmt.Errorf("error decoding manifest: %v", err)
+	}
+	return m, nil
+}
+
+// ParsePath extracts the namespace and resource from an archive entry path.
+// Cluster-scoped entries are stored as cluster-scoped/<resource>/<name> and
+// namespaced entries as namespace-scoped/<namespace>/<resource>/<name>.
+func ParsePath(name string) (string, string, bool) {
+	name = strings.TrimPrefix(path.Clean(name), "./")
+	if ext := path.Ext(name); ext != ".yaml" && ext != ".json" {
+		return "", "", false
+	}
+
+	parts := strings.Split(name, "/")
+	switch {
+	case len(parts) == 3 && parts[0] == "cluster-scoped":
+		return "", parts[1], true
+	case len(parts) == 4 && parts[0] == "namespace-scoped":
+		return parts[1], parts[2], true
+	default:
+		return "", "", false
+	}
+}
+
+// Checksum returns the hex-encoded SHA-256 of everything read from r.
+func Checksum(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", fmt.Errorf("error calculating checksum: %v", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// FileChecksum returns the hex-encoded SHA-256 of a file.
+func FileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("error opening file: %v", err)
+	}
+	defer file.Close()
+	return Checksum(file)
+}
+
+// ChecksumFile returns the contents of a sha256sum-compatible checksum file for an archive.
+func ChecksumFile(checksum, archiveName string) string {
+	return checksum + "  " + archiveName + "\n"
+}
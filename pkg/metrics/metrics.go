@@ -3,6 +3,8 @@ package metrics
 import (
 	"context"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -33,6 +35,17 @@ var (
 		Name: "kubebackup_namespaces_total",
 		Help: "Total number of namespaces being backed up.",
 	})
+
+	workQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kubebackup_work_queue_depth",
+		Help: "Number of namespace and resource jobs waiting for a backup worker.",
+	})
+
+	apiThrottleWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kubebackup_api_throttle_wait_seconds",
+		Help:    "Time Kubernetes API requests spent waiting for the client-side rate limiter.",
+		Buckets: []float64{0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	})
 )
 
 func init() {
@@ -41,6 +54,8 @@ func init() {
 	prometheus.MustRegister(backupSuccess)
 	prometheus.MustRegister(objectCount)
 	prometheus.MustRegister(namespacesTotal)
+	prometheus.MustRegister(workQueueDepth)
+	prometheus.MustRegister(apiThrottleWait)
 }
 
 func StartMetricsServer(ctx context.Context, metricsPort string) error {
@@ -84,3 +99,14 @@ func WriteObjectCount(objectType string, count int) {
 func WriteNamespaceCount(count int) {
 	namespacesTotal.Set(float64(count))
 }
+
+func WriteQueueDepth(depth int) {
+	workQueueDepth.Set(float64(depth))
+}
+
+// ThrottleWaitMetric records client-go rate limiter latency in the API throttle wait histogram.
+type ThrottleWaitMetric struct{}
+
+func (ThrottleWaitMetric) Observe(ctx context.Context, verb string, u url.URL, latency time.Duration) {
+	apiThrottleWait.Observe(latency.Seconds())
+}
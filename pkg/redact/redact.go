@@ -0,0 +1,161 @@
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mattmattox/kubebackup/pkg/config"
+	"github.com/mattmattox/kubebackup/pkg/fieldpath"
+	"github.com/mattmattox/kubebackup/pkg/filter"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Annotation lists the value paths that were replaced with redaction placeholders in an archived object.
+const Annotation = "kubebackup.io/redacted-fields"
+
+// Redactor replaces sensitive values with keyed hashes so archives can be shared without exposing them,
+// while still showing whether a value changed between two backups.
+type Redactor struct {
+	rules       []fieldpath.Rule
+	envPatterns []string
+	salt        []byte
+}
+
+// NewRedactor returns a Redactor for the configured fields and env patterns, or nil if redaction is disabled.
+// A salt is required, since unkeyed hashes of short values can be reversed by hashing candidates.
+func NewRedactor(cfg *config.AppConfig) (*Redactor, error) {
+	if !cfg.Redact {
+		return nil, nil
+	}
+	if cfg.RedactSalt == "" {
+		return nil, fmt.Errorf("REDACT_SALT must be set when REDACT is enabled")
+	}
+	rules, err := fieldpath.ParseRules(cfg.RedactFields)
+	if err != nil {
+		return nil, err
+	}
+	if err := filter.ValidatePatterns(cfg.RedactEnvPatterns); err != nil {
+		return nil, err
+	}
+
+	// Env var names are matched case-insensitively
+	envPatterns := make([]string, len(cfg.RedactEnvPatterns))
+	for i, pattern := range cfg.RedactEnvPatterns {
+		envPatterns[i] = strings.ToUpper(pattern)
+	}
+	return &Redactor{
+		rules:       rules,
+		envPatterns: envPatterns,
+		salt:        []byte(cfg.RedactSalt),
+	}, nil
+}
+
+// RedactObject replaces the selected fields and the values of env vars whose names match the env patterns in place,
+// and records the replaced value paths in the Annotation.
+func (r *Redactor) RedactObject(object *unstructured.Unstructured, resource schema.GroupVersionResource) error {
+	var redacted []string
+	placeholder := func(value interface{}, valuePath string) (interface{}, error) {
+		redacted = append(redacted, valuePath)
+		return r.Placeholder(value)
+	}
+
+	for _, rule := range r.rules {
+		if !rule.Matches(resource) {
+			continue
+		}
+		if err := fieldpath.Transform(object.Object, rule.Path, placeholder); err != nil {
+			return fmt.Errorf("error redacting field '%s': %v", rule.String(), err)
+		}
+	}
+	if len(r.envPatterns) > 0 {
+		if err := r.redactEnv(object.Object, "", placeholder); err != nil {
+			return err
+		}
+	}
+	if len(redacted) == 0 {
+		return nil
+	}
+
+	sort.Strings(redacted)
+	annotations := object.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	delete(annotations, fieldpath.LastAppliedAnnotation)
+	annotations[Annotation] = strings.Join(redacted, ",")
+	object.SetAnnotations(annotations)
+	return nil
+}
+
+// redactEnv walks the object for container env lists and replaces the values of the matching env vars.
+func (r *Redactor) redactEnv(value interface{}, valuePath string, placeholder fieldpath.TransformFunc) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			itemPath := key
+			if valuePath != "" {
+				itemPath = valuePath + "." + key
+			}
+			if env, ok := item.([]interface{}); ok && key == "env" {
+				for i, envVar := range env {
+					if err := r.redactEnvVar(envVar, fmt.Sprintf("%s[%d]", itemPath, i), placeholder); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if err := r.redactEnv(item, itemPath, placeholder); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			if err := r.redactEnv(item, fmt.Sprintf("%s[%d]", valuePath, i), placeholder); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Redactor) redactEnvVar(envVar interface{}, valuePath string, placeholder fieldpath.TransformFunc) error {
+	m, ok := envVar.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	name, _ := m["name"].(string)
+	if _, hasValue := m["value"]; !hasValue || !filter.MatchAny(r.envPatterns, strings.ToUpper(name)) {
+		return nil
+	}
+	return fieldpath.Transform(m, []string{"value"}, func(value interface{}, _ string) (interface{}, error) {
+		return placeholder(value, valuePath+".value")
+	})
+}
+
+// Placeholder returns the redaction placeholder for a value: an HMAC-SHA256 of the value keyed with the salt.
+func (r *Redactor) Placeholder(value interface{}) (string, error) {
+	data, ok := value.(string)
+	if !ok {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		data = string(encoded)
+	}
+
+	mac := hmac.New(sha256.New, r.salt)
+	mac.Write([]byte(data))
+	return "REDACTED[hmac-sha256:" + hex.EncodeToString(mac.Sum(nil)) + "]", nil
+}
+
+// IsRedacted reports whether an archived object had values replaced by a Redactor.
+func IsRedacted(object *unstructured.Unstructured) bool {
+	_, ok := object.GetAnnotations()[Annotation]
+	return ok
+}
@@ -0,0 +1,209 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// Conflict policies applied when an archived object already exists in the cluster.
+const (
+	PolicySkip      = "skip"
+	PolicyOverwrite = "overwrite"
+	PolicyApply     = "apply"
+	PolicyFail      = "fail"
+)
+
+// fieldManager is the field manager used for server-side apply.
+const fieldManager = "kubebackup"
+
+// errConflict is returned when an object already exists and the policy is PolicyFail.
+var errConflict = errors.New("object already exists")
+
+// errNamespaceNotCreated is returned by a dry run for objects whose namespace is only created by the same dry run.
+var errNamespaceNotCreated = errors.New("namespace not yet created")
+
+// ValidateConflictPolicy returns an error if policy is not a known conflict policy.
+func ValidateConflictPolicy(policy string) error {
+	switch policy {
+	case PolicySkip, PolicyOverwrite, PolicyApply, PolicyFail:
+		return nil
+	default:
+		return fmt.Errorf("invalid conflict policy '%s': must be one of %s, %s, %s or %s", policy, PolicySkip, PolicyOverwrite, PolicyApply, PolicyFail)
+	}
+}
+
+// prepareForCreate removes the server-populated metadata that the API server rejects on create.
+func prepareForCreate(object *unstructured.Unstructured) *unstructured.Unstructured {
+	prepared := object.DeepCopy()
+	prepared.SetResourceVersion("")
+	prepared.SetUID("")
+	prepared.SetSelfLink("")
+	prepared.SetGeneration(0)
+	prepared.SetCreationTimestamp(metav1.Time{})
+	prepared.SetManagedFields(nil)
+	return prepared
+}
+
+// rewriteOwnerReferences points owner references at the restored owners' new UIDs
+// and drops references to owners that were not restored.
+func (r *restorer) rewriteOwnerReferences(object *unstructured.Unstructured, namespace string) {
+	refs := object.GetOwnerReferences()
+	if len(refs) == 0 {
+		return
+	}
+
+	rewritten := make([]metav1.OwnerReference, 0, len(refs))
+	for _, ref := range refs {
+		uid, ok := r.ownerUID(ref, namespace)
+		if !ok {
+			log.Debugf("Dropping owner reference to %s '%s' from '%s': owner was not restored", ref.Kind, ref.Name, object.GetName())
+			continue
+		}
+		ref.UID = uid
+		rewritten = append(rewritten, ref)
+	}
+	object.SetOwnerReferences(rewritten)
+}
+
+// restoreObject creates an archived object and resolves conflicts with existing objects according to the conflict policy.
+func (r *restorer) restoreObject(ctx context.Context, gvr schema.GroupVersionResource, object ArchivedObject) (string, error) {
+	log.Infof("Restoring %s '%s' in namespace '%s'", gvr.Resource, object.Object.GetName(), object.Namespace)
+
+	var resourceClient dynamic.ResourceInterface = r.dynamicClient.Resource(gvr)
+	if object.Namespace != "" {
+		resourceClient = r.dynamicClient.Resource(gvr).Namespace(object.Namespace)
+	}
+
+	prepared := prepareForCreate(object.Object)
+	r.rewriteOwnerReferences(prepared, object.Namespace)
+
+	if r.policy == PolicyApply {
+		applied, err := resourceClient.Apply(ctx, prepared.GetName(), prepared, metav1.ApplyOptions{FieldManager: fieldManager, Force: true})
+		if err != nil {
+			return ActionFailed, fmt.Errorf("error applying object: %v", err)
+		}
+		r.recordUID(object, applied)
+		return ActionApplied, nil
+	}
+
+	created, err := resourceClient.Create(ctx, prepared, metav1.CreateOptions{})
+	if err == nil {
+		r.recordUID(object, created)
+		return ActionCreated, nil
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return ActionFailed, fmt.Errorf("error creating object: %v", err)
+	}
+
+	existing, err := resourceClient.Get(ctx, prepared.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return ActionFailed, fmt.Errorf("error fetching existing object: %v", err)
+	}
+	r.recordUID(object, existing)
+
+	switch r.policy {
+	case PolicyOverwrite:
+		prepared.SetResourceVersion(existing.GetResourceVersion())
+		if _, err := resourceClient.Update(ctx, prepared, metav1.UpdateOptions{}); err != nil {
+			return ActionFailed, fmt.Errorf("error updating object: %v", err)
+		}
+		return ActionUpdated, nil
+	case PolicyFail:
+		return ActionFailed, fmt.Errorf("%s '%s': %w", gvr.Resource, prepared.GetName(), errConflict)
+	default:
+		log.Infof("Object %s '%s' already exists, skipping", gvr.Resource, prepared.GetName())
+		return ActionSkipped, nil
+	}
+}
+
+// recordUID records the UID of the live counterpart of an archived object.
+func (r *restorer) recordUID(object ArchivedObject, live *unstructured.Unstructured) {
+	r.uids[archivedObjectKey(object)] = live.GetUID()
+}
+
+// ownerUID returns the live UID of a restored owner.
+func (r *restorer) ownerUID(ref metav1.OwnerReference, namespace string) (types.UID, bool) {
+	for _, key := range ownerKeys(ref, namespace) {
+		if uid, ok := r.uids[key]; ok {
+			return uid, true
+		}
+	}
+	return "", false
+}
+
+// dryRunObject sends an archived object to the API server with DryRun=All and returns the action the
+// restore would take, along with the fields an update would change.
+func (r *restorer) dryRunObject(ctx context.Context, gvr schema.GroupVersionResource, object ArchivedObject) (string, []string, error) {
+	log.Infof("Dry-run restoring %s '%s' in namespace '%s'", gvr.Resource, object.Object.GetName(), object.Namespace)
+
+	var resourceClient dynamic.ResourceInterface = r.dynamicClient.Resource(gvr)
+	if object.Namespace != "" {
+		resourceClient = r.dynamicClient.Resource(gvr).Namespace(object.Namespace)
+	}
+
+	prepared := prepareForCreate(object.Object)
+	r.rewriteOwnerReferences(prepared, object.Namespace)
+	dryRun := []string{metav1.DryRunAll}
+
+	existing, err := resourceClient.Get(ctx, prepared.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		var created *unstructured.Unstructured
+		if r.policy == PolicyApply {
+			created, err = resourceClient.Apply(ctx, prepared.GetName(), prepared, metav1.ApplyOptions{FieldManager: fieldManager, Force: true, DryRun: dryRun})
+		} else {
+			created, err = resourceClient.Create(ctx, prepared, metav1.CreateOptions{DryRun: dryRun})
+		}
+		if apierrors.IsNotFound(err) && r.dryRunNamespaces[object.Namespace] {
+			log.Infof("Namespace '%s' does not exist yet, skipping validation of %s '%s'", object.Namespace, gvr.Resource, prepared.GetName())
+			return ActionUnvalidated, nil, errNamespaceNotCreated
+		}
+		if err != nil {
+			return ActionFailed, nil, fmt.Errorf("error creating object: %v", err)
+		}
+		r.recordUID(object, created)
+		if gvr.GroupResource().String() == "namespaces" {
+			r.dryRunNamespaces[prepared.GetName()] = true
+		}
+		return ActionCreated, nil, nil
+	}
+	if err != nil {
+		return ActionFailed, nil, fmt.Errorf("error fetching existing object: %v", err)
+	}
+	r.recordUID(object, existing)
+
+	var result *unstructured.Unstructured
+	switch r.policy {
+	case PolicyOverwrite:
+		prepared.SetResourceVersion(existing.GetResourceVersion())
+		result, err = resourceClient.Update(ctx, prepared, metav1.UpdateOptions{DryRun: dryRun})
+		if err != nil {
+			return ActionFailed, nil, fmt.Errorf("error updating object: %v", err)
+		}
+	case PolicyApply:
+		result, err = resourceClient.Apply(ctx, prepared.GetName(), prepared, metav1.ApplyOptions{FieldManager: fieldManager, Force: true, DryRun: dryRun})
+		if err != nil {
+			return ActionFailed, nil, fmt.Errorf("error applying object: %v", err)
+		}
+	case PolicyFail:
+		return ActionFailed, nil, fmt.Errorf("%s '%s': %v", gvr.Resource, prepared.GetName(), errConflict)
+	default:
+		return ActionSkipped, nil, nil
+	}
+
+	changes := diffObjects(existing, result)
+	if len(changes) == 0 {
+		return ActionUnchanged, nil, nil
+	}
+	if r.policy == PolicyApply {
+		return ActionApplied, changes, nil
+	}
+	return ActionUpdated, changes, nil
+}
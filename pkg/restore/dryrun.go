@@ -0,0 +1,86 @@
+package restore
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ignoredDiffFields are the fields that the API server manages and that never differ meaningfully between
+// a live object and its dry-run result.
+var ignoredDiffFields = [][]string{
+	{"metadata", "resourceVersion"},
+	{"metadata", "generation"},
+	{"metadata", "managedFields"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "uid"},
+	{"metadata", "selfLink"},
+	{"status"},
+}
+
+// diffObjects returns the dotted paths of the fields that differ between the live object and its dry-run result.
+func diffObjects(live, result *unstructured.Unstructured) []string {
+	before := live.DeepCopy().Object
+	after := result.DeepCopy().Object
+	for _, field := range ignoredDiffFields {
+		unstructured.RemoveNestedField(before, field...)
+		unstructured.RemoveNestedField(after, field...)
+	}
+
+	var changes []string
+	diffValues("", before, after, &changes)
+	sort.Strings(changes)
+	return changes
+}
+
+func diffValues(prefix string, before, after interface{}, changes *[]string) {
+	beforeMap, beforeIsMap := before.(map[string]interface{})
+	afterMap, afterIsMap := after.(map[string]interface{})
+	if !beforeIsMap || !afterIsMap {
+		if !reflect.DeepEqual(before, after) {
+			*changes = append(*changes, prefix)
+		}
+		return
+	}
+
+	keys := make(map[string]bool, len(beforeMap)+len(afterMap))
+	for key := range beforeMap {
+		keys[key] = true
+	}
+	for key := range afterMap {
+		keys[key] = true
+	}
+	for key := range keys {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		diffValues(path, beforeMap[key], afterMap[key], changes)
+	}
+}
+
+// PrintPreview writes a per-object table of the actions a dry-run restore would take.
+func (rep *Report) PrintPreview(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tRESOURCE\tNAME\tDETAILS")
+	for _, result := range rep.Objects {
+		name := result.Name
+		if result.Namespace != "" {
+			name = result.Namespace + "/" + result.Name
+		}
+		details := result.Error
+		if details == "" {
+			details = result.Reason
+		}
+		if details == "" {
+			details = strings.Join(result.Changes, ", ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Action, result.Resource, name, details)
+	}
+	return tw.Flush()
+}
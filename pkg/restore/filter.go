@@ -0,0 +1,104 @@
+package restore
+
+import (
+	"fmt"
+
+	"github.com/mattmattox/kubebackup/pkg/config"
+	"github.com/mattmattox/kubebackup/pkg/filter"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Filter selects which archived objects are restored.
+type Filter struct {
+	IncludeNamespaces []string
+	ExcludeNamespaces []string
+	IncludeResources  []string
+	ExcludeResources  []string
+	IncludeNames      []string
+	ExcludeNames      []string
+	LabelSelector     labels.Selector
+}
+
+// NewFilter builds a Filter from the restore settings in cfg.
+func NewFilter(cfg *config.AppConfig) (*Filter, error) {
+	for _, patterns := range [][]string{
+		cfg.RestoreIncludeNamespaces, cfg.RestoreExcludeNamespaces,
+		cfg.RestoreIncludeResources, cfg.RestoreExcludeResources,
+		cfg.RestoreIncludeNames, cfg.RestoreExcludeNames,
+	} {
+		if err := filter.ValidatePatterns(patterns); err != nil {
+			return nil, err
+		}
+	}
+
+	selector := labels.Everything()
+	if cfg.RestoreLabelSelector != "" {
+		var err error
+		selector, err = labels.Parse(cfg.RestoreLabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector '%s': %v", cfg.RestoreLabelSelector, err)
+		}
+	}
+
+	return &Filter{
+		IncludeNamespaces: cfg.RestoreIncludeNamespaces,
+		ExcludeNamespaces: cfg.RestoreExcludeNamespaces,
+		IncludeResources:  cfg.RestoreIncludeResources,
+		ExcludeResources:  cfg.RestoreExcludeResources,
+		IncludeNames:      cfg.RestoreIncludeNames,
+		ExcludeNames:      cfg.RestoreExcludeNames,
+		LabelSelector:     selector,
+	}, nil
+}
+
+// Matches reports whether an archived object passes the filter.
+// When namespaces are included explicitly, cluster-scoped objects are skipped
+// except for the Namespace objects of the included namespaces.
+func (f *Filter) Matches(object ArchivedObject) bool {
+	gvr, err := resourceFor(object)
+	if err != nil {
+		return false
+	}
+
+	namespace := object.Namespace
+	if namespace == "" {
+		if gvr.GroupResource().String() != "namespaces" {
+			if len(f.IncludeNamespaces) > 0 {
+				return false
+			}
+		} else {
+			namespace = object.Object.GetName()
+		}
+	}
+	if namespace != "" && !filter.Allowed(f.IncludeNamespaces, f.ExcludeNamespaces, namespace) {
+		return false
+	}
+
+	// Resources can be matched as "resource" or "resource.group"
+	resource := gvr.GroupResource().String()
+	if len(f.IncludeResources) > 0 && !filter.MatchAny(f.IncludeResources, resource) && !filter.MatchAny(f.IncludeResources, gvr.Resource) {
+		return false
+	}
+	if filter.MatchAny(f.ExcludeResources, resource) || filter.MatchAny(f.ExcludeResources, gvr.Resource) {
+		return false
+	}
+
+	if !filter.Allowed(f.IncludeNames, f.ExcludeNames, object.Object.GetName()) {
+		return false
+	}
+
+	return f.LabelSelector.Matches(labels.Set(object.Object.GetLabels()))
+}
+
+// filterObjects returns the archived objects that pass the filter.
+func filterObjects(objects []ArchivedObject, f *Filter) []ArchivedObject {
+	filtered := make([]ArchivedObject, 0, len(objects))
+	for _, object := range objects {
+		if f.Matches(object) {
+			filtered = append(filtered, object)
+		} else {
+			log.Debugf("Skipping object '%s': excluded by restore filters", object.Path)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,214 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattmattox/kubebackup/pkg/k8s"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	phaseCRDs = iota
+	phaseNamespaces
+	phaseClusterInfra
+	phaseNamespaceInfra
+	phaseWorkloads
+)
+
+const (
+	crdEstablishTimeout = 2 * time.Minute
+	crdPollInterval     = 2 * time.Second
+	maxRetryPasses      = 3
+	retryDelay          = 5 * time.Second
+)
+
+var crdResource = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// resourcePhases maps "resource.group" to the restore phase the resource belongs to.
+// Anything not listed here is restored in phaseWorkloads.
+var resourcePhases = map[string]int{
+	"customresourcedefinitions.apiextensions.k8s.io": phaseCRDs,
+	"namespaces":                                    phaseNamespaces,
+	"storageclasses.storage.k8s.io":                 phaseClusterInfra,
+	"priorityclasses.scheduling.k8s.io":             phaseClusterInfra,
+	"runtimeclasses.node.k8s.io":                    phaseClusterInfra,
+	"ingressclasses.networking.k8s.io":              phaseClusterInfra,
+	"clusterroles.rbac.authorization.k8s.io":        phaseClusterInfra,
+	"clusterrolebindings.rbac.authorization.k8s.io": phaseClusterInfra,
+	"persistentvolumes":                             phaseClusterInfra,
+	"serviceaccounts":                               phaseNamespaceInfra,
+	"roles.rbac.authorization.k8s.io":               phaseNamespaceInfra,
+	"rolebindings.rbac.authorization.k8s.io":        phaseNamespaceInfra,
+	"secrets":                                       phaseNamespaceInfra,
+	"configmaps":                                    phaseNamespaceInfra,
+	"limitranges":                                   phaseNamespaceInfra,
+	"resourcequotas":                                phaseNamespaceInfra,
+	"persistentvolumeclaims":                        phaseNamespaceInfra,
+	"networkpolicies.networking.k8s.io":             phaseNamespaceInfra,
+}
+
+// phaseFor returns the restore phase for a GroupVersionResource.
+func phaseFor(gvr schema.GroupVersionResource) int {
+	if phase, ok := resourcePhases[gvr.GroupResource().String()]; ok {
+		return phase
+	}
+	return phaseWorkloads
+}
+
+// objectKey identifies an object by group, kind, namespace and name, so that owners can be
+// matched to their dependents even when the archive was sanitized of UIDs.
+func objectKey(apiVersion, kind, namespace, name string) string {
+	gv, _ := schema.ParseGroupVersion(apiVersion)
+	return strings.Join([]string{gv.Group, kind, namespace, name}, "/")
+}
+
+// archivedObjectKey returns the objectKey of an archived object.
+func archivedObjectKey(object ArchivedObject) string {
+	return objectKey(object.Object.GetAPIVersion(), object.Object.GetKind(), object.Namespace, object.Object.GetName())
+}
+
+// ownerKeys returns the candidate objectKeys of an owner. Owners are either in the
+// dependent's namespace or cluster-scoped.
+func ownerKeys(ref metav1.OwnerReference, namespace string) []string {
+	keys := []string{objectKey(ref.APIVersion, ref.Kind, namespace, ref.Name)}
+	if namespace != "" {
+		keys = append(keys, objectKey(ref.APIVersion, ref.Kind, "", ref.Name))
+	}
+	return keys
+}
+
+// orderObjects groups archived objects into restore phases. Objects are placed
+// no earlier than the phase of their owners and, within a phase, after them.
+func orderObjects(objects []ArchivedObject) [][]ArchivedObject {
+	byKey := make(map[string]int, len(objects))
+	for i, object := range objects {
+		byKey[archivedObjectKey(object)] = i
+	}
+
+	phases := make([]int, len(objects))
+	depths := make([]int, len(objects))
+	visited := make([]bool, len(objects))
+
+	var visit func(i int)
+	visit = func(i int) {
+		if visited[i] {
+			return
+		}
+		visited[i] = true
+
+		phase := phaseWorkloads
+		if gvr, err := resourceFor(objects[i]); err == nil {
+			phase = phaseFor(gvr)
+		}
+		depth := 0
+		for _, ref := range objects[i].Object.GetOwnerReferences() {
+			owner, ok := lookupOwner(byKey, ref, objects[i].Namespace)
+			if !ok || owner == i {
+				continue
+			}
+			visit(owner)
+			if phases[owner] > phase {
+				phase = phases[owner]
+			}
+			if depths[owner]+1 > depth {
+				depth = depths[owner] + 1
+			}
+		}
+		phases[i] = phase
+		depths[i] = depth
+	}
+
+	for i := range objects {
+		visit(i)
+	}
+
+	indexes := make([]int, len(objects))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(a, b int) bool {
+		if phases[indexes[a]] != phases[indexes[b]] {
+			return phases[indexes[a]] < phases[indexes[b]]
+		}
+		return depths[indexes[a]] < depths[indexes[b]]
+	})
+
+	ordered := make([][]ArchivedObject, phaseWorkloads+1)
+	for _, i := range indexes {
+		ordered[phases[i]] = append(ordered[phases[i]], objects[i])
+	}
+	return ordered
+}
+
+func lookupOwner(byKey map[string]int, ref metav1.OwnerReference, namespace string) (int, bool) {
+	for _, key := range ownerKeys(ref, namespace) {
+		if owner, ok := byKey[key]; ok {
+			return owner, true
+		}
+	}
+	return 0, false
+}
+
+// discoverResources returns the set of resources currently served by the cluster.
+func discoverResources(clientset *kubernetes.Clientset) (map[schema.GroupVersionResource]bool, error) {
+	clusterScoped, _, err := k8s.GetClusterScopedResources(clientset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching cluster-scoped resources: %v", err)
+	}
+	namespaced, _, err := k8s.GetNamespaceScopedResources(clientset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching namespaced resources: %v", err)
+	}
+
+	resources := make(map[schema.GroupVersionResource]bool, len(clusterScoped)+len(namespaced))
+	for _, resource := range clusterScoped {
+		resources[resource] = true
+	}
+	for _, resource := range namespaced {
+		resources[resource] = true
+	}
+	return resources, nil
+}
+
+// waitForCRDEstablished blocks until the named CRD reports the Established condition.
+func waitForCRDEstablished(ctx context.Context, dynamicClient dynamic.Interface, name string) error {
+	log.Infof("Waiting for CRD %s to become established...", name)
+	err := wait.PollImmediateWithContext(ctx, crdPollInterval, crdEstablishTimeout, func(ctx context.Context) (bool, error) {
+		crd, err := dynamicClient.Resource(crdResource).Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, err
+		}
+		if err != nil {
+			return false, nil
+		}
+		return isEstablished(crd), nil
+	})
+	if err != nil {
+		return fmt.Errorf("CRD %s was not established: %v", name, err)
+	}
+	return nil
+}
+
+func isEstablished(crd *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	for _, condition := range conditions {
+		c, ok := condition.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if c["type"] == "Established" && c["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package restore
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// namespaceLabel is the immutable label the API server sets on every Namespace.
+const namespaceLabel = "kubernetes.io/metadata.name"
+
+// parseNamespaceMappings parses "src:dst" entries into a lookup table.
+func parseNamespaceMappings(entries []string) (map[string]string, error) {
+	mappings := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid namespace mapping '%s': expected src:dst", entry)
+		}
+		if _, exists := mappings[parts[0]]; exists {
+			return nil, fmt.Errorf("duplicate namespace mapping for '%s'", parts[0])
+		}
+		mappings[parts[0]] = parts[1]
+	}
+	return mappings, nil
+}
+
+// remapNamespaces rewrites the namespace of each archived object, the names of
+// mapped Namespace objects, and the namespaces referenced by RBAC binding subjects.
+func remapNamespaces(objects []ArchivedObject, mappings map[string]string) []ArchivedObject {
+	if len(mappings) == 0 {
+		return objects
+	}
+
+	remapped := make([]ArchivedObject, 0, len(objects))
+	for _, object := range objects {
+		gvr, err := resourceFor(object)
+		if err != nil {
+			remapped = append(remapped, object)
+			continue
+		}
+
+		object.Object = object.Object.DeepCopy()
+		switch gvr.GroupResource().String() {
+		case "namespaces":
+			if target, ok := mappings[object.Object.GetName()]; ok {
+				log.Debugf("Remapping namespace %s to %s", object.Object.GetName(), target)
+				object.Object.SetName(target)
+				remapNamespaceLabel(object.Object, target)
+			}
+		case "rolebindings.rbac.authorization.k8s.io":
+			// A binding that stays in an unmapped namespace keeps granting access to the source namespace
+			_, moved := mappings[object.Namespace]
+			remapSubjects(object.Object, mappings, !moved)
+		case "clusterrolebindings.rbac.authorization.k8s.io":
+			// Other workloads may still use the source namespace, so keep its subjects bound
+			remapSubjects(object.Object, mappings, true)
+		}
+
+		if target, ok := mappings[object.Namespace]; ok {
+			log.Debugf("Remapping '%s' from namespace %s to %s", object.Path, object.Namespace, target)
+			object.Namespace = target
+			object.Object.SetNamespace(target)
+		}
+		remapped = append(remapped, object)
+	}
+	return remapped
+}
+
+func remapNamespaceLabel(object *unstructured.Unstructured, namespace string) {
+	objectLabels := object.GetLabels()
+	if _, ok := objectLabels[namespaceLabel]; !ok {
+		return
+	}
+	objectLabels[namespaceLabel] = namespace
+	object.SetLabels(objectLabels)
+}
+
+// remapSubjects rewrites the namespace of ServiceAccount subjects in a RoleBinding or ClusterRoleBinding.
+// With keepOriginal, the remapped subjects are added next to the original ones instead of replacing them.
+func remapSubjects(object *unstructured.Unstructured, mappings map[string]string, keepOriginal bool) {
+	subjects, found, err := unstructured.NestedSlice(object.Object, "subjects")
+	if err != nil || !found {
+		return
+	}
+
+	remapped := make([]interface{}, 0, len(subjects))
+	seen := make(map[string]bool, len(subjects))
+	for _, subject := range subjects {
+		if s, ok := subject.(map[string]interface{}); ok {
+			seen[subjectKey(s)] = true
+		}
+	}
+	for _, subject := range subjects {
+		s, ok := subject.(map[string]interface{})
+		if !ok {
+			remapped = append(remapped, subject)
+			continue
+		}
+		namespace, _ := s["namespace"].(string)
+		target, ok := mappings[namespace]
+		if !ok {
+			remapped = append(remapped, s)
+			continue
+		}
+
+		moved := runtime.DeepCopyJSON(s)
+		moved["namespace"] = target
+		if keepOriginal {
+			remapped = append(remapped, s)
+			if seen[subjectKey(moved)] {
+				continue
+			}
+			seen[subjectKey(moved)] = true
+		}
+		remapped = append(remapped, moved)
+	}
+
+	if err := unstructured.SetNestedSlice(object.Object, remapped, "subjects"); err != nil {
+		log.Errorf("Error remapping subjects of '%s': %v", object.GetName(), err)
+	}
+}
+
+// subjectKey identifies an RBAC subject by kind, namespace and name.
+func subjectKey(subject map[string]interface{}) string {
+	kind, _ := subject["kind"].(string)
+	namespace, _ := subject["namespace"].(string)
+	name, _ := subject["name"].(string)
+	return kind + "/" + namespace + "/" + name
+}
@@ -0,0 +1,120 @@
+package restore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Actions recorded for each object in a restore report. ActionUnvalidated marks objects a dry run could not
+// validate because their CRD or namespace is only in the archive.
+const (
+	ActionCreated     = "created"
+	ActionUpdated     = "updated"
+	ActionApplied     = "applied"
+	ActionSkipped     = "skipped"
+	ActionUnchanged   = "unchanged"
+	ActionFailed      = "failed"
+	ActionUnvalidated = "unvalidated"
+)
+
+// ObjectResult is the outcome of restoring a single archived object.
+type ObjectResult struct {
+	Path      string   `json:"path"`
+	Resource  string   `json:"resource"`
+	Namespace string   `json:"namespace,omitempty"`
+	Name      string   `json:"name"`
+	Action    string   `json:"action"`
+	Changes   []string `json:"changes,omitempty"`
+	Reason    string   `json:"reason,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// Report summarizes a restore run.
+type Report struct {
+	Archive        string         `json:"archive"`
+	ConflictPolicy string         `json:"conflictPolicy"`
+	DryRun         bool           `json:"dryRun"`
+	StartTime      time.Time      `json:"startTime"`
+	EndTime        time.Time      `json:"endTime"`
+	Summary        map[string]int `json:"summary"`
+	Objects        []ObjectResult `json:"objects"`
+}
+
+func newReport(archive, policy string, dryRun bool) *Report {
+	return &Report{
+		Archive:        archive,
+		ConflictPolicy: policy,
+		DryRun:         dryRun,
+		StartTime:      time.Now(),
+		Summary:        make(map[string]int),
+	}
+}
+
+// add records the outcome for an archived object.
+func (rep *Report) add(object ArchivedObject, resource, action string, err error) {
+	rep.addChanges(object, resource, action, nil, err)
+}
+
+// addReason records the outcome for an archived object along with why it was not restored or validated.
+func (rep *Report) addReason(object ArchivedObject, resource, action, reason string) {
+	rep.addChanges(object, resource, action, nil, nil)
+	rep.Objects[len(rep.Objects)-1].Reason = reason
+}
+
+// addChanges records the outcome for an archived object along with the fields a dry run would change.
+func (rep *Report) addChanges(object ArchivedObject, resource, action string, changes []string, err error) {
+	result := ObjectResult{
+		Path:      object.Path,
+		Resource:  resource,
+		Namespace: object.Namespace,
+		Name:      object.Object.GetName(),
+		Action:    action,
+		Changes:   changes,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	rep.Objects = append(rep.Objects, result)
+	rep.Summary[action]++
+}
+
+// fail marks an archived object that was already recorded as failed with err, or records it as failed if it was
+// not recorded yet.
+func (rep *Report) fail(object ArchivedObject, resource string, err error) {
+	for i := range rep.Objects {
+		result := &rep.Objects[i]
+		if result.Path != object.Path {
+			continue
+		}
+		rep.Summary[result.Action]--
+		if rep.Summary[result.Action] == 0 {
+			delete(rep.Summary, result.Action)
+		}
+		rep.Summary[ActionFailed]++
+		result.Action = ActionFailed
+		result.Error = err.Error()
+		return
+	}
+	rep.add(object, resource, ActionFailed, err)
+}
+
+// Failed returns the number of objects that could not be restored.
+func (rep *Report) Failed() int {
+	return rep.Summary[ActionFailed]
+}
+
+// Write saves the report as JSON to reportPath.
+func (rep *Report) Write(reportPath string) error {
+	rep.EndTime = time.Now()
+
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling restore report: %v", err)
+	}
+	if err := os.WriteFile(reportPath, data, 0600); err != nil {
+		return fmt.Errorf("error writing restore report '%s': %v", reportPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,421 @@
+package restore
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mattmattox/kubebackup/pkg/compression"
+	"github.com/mattmattox/kubebackup/pkg/config"
+	"github.com/mattmattox/kubebackup/pkg/encryption"
+	"github.com/mattmattox/kubebackup/pkg/logging"
+	"github.com/mattmattox/kubebackup/pkg/manifest"
+	"github.com/mattmattox/kubebackup/pkg/redact"
+	"github.com/mattmattox/kubebackup/pkg/signing"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+var log = logging.SetupLogging()
+
+// ArchivedObject is a single Kubernetes object read from a backup archive.
+type ArchivedObject struct {
+	Path      string
+	Namespace string
+	Resource  string
+	Object    *unstructured.Unstructured
+}
+
+// restorer holds the state shared across a single restore run.
+type restorer struct {
+	clientset     *kubernetes.Clientset
+	dynamicClient dynamic.Interface
+	resources     map[schema.GroupVersionResource]bool
+	uids          map[string]types.UID
+	policy        string
+	dryRun        bool
+	report        *Report
+
+	// dryRunNamespaces records the namespaces a dry run would create
+	dryRunNamespaces map[string]bool
+}
+
+// StartRestore reads the archive configured in cfg.RestoreFile and applies every object it contains to the cluster.
+// Canceling ctx stops the restore before the next object; the report still lists the objects handled so far.
+func StartRestore(ctx context.Context, clientset *kubernetes.Clientset, dynamicClient dynamic.Interface, cfg *config.AppConfig) error {
+	if cfg.RestoreFile == "" {
+		return fmt.Errorf("no restore file specified")
+	}
+
+	restoreFilter, err := NewFilter(cfg)
+	if err != nil {
+		return fmt.Errorf("error building restore filter: %v", err)
+	}
+
+	if err := ValidateConflictPolicy(cfg.RestoreConflictPolicy); err != nil {
+		return err
+	}
+
+	namespaceMappings, err := parseNamespaceMappings(cfg.RestoreNamespaceMappings)
+	if err != nil {
+		return err
+	}
+
+	if cfg.SigningPublicKeyFile != "" {
+		signaturePath := cfg.RestoreSignatureFile
+		if signaturePath == "" {
+			signaturePath = cfg.RestoreFile + signing.Extension
+		}
+		log.Infof("Verifying signature of backup archive %s...", cfg.RestoreFile)
+		if err := signing.VerifyFile(cfg.RestoreFile, signaturePath, cfg.SigningPublicKeyFile); err != nil {
+			return fmt.Errorf("refusing to restore archive: %v", err)
+		}
+		log.Infoln("Backup archive signature is valid.")
+	}
+
+	log.Infof("Reading backup archive %s...", cfg.RestoreFile)
+	file, err := os.Open(cfg.RestoreFile)
+	if err != nil {
+		return fmt.Errorf("error opening backup archive: %v", err)
+	}
+	defer file.Close()
+
+	archiveReader, err := encryption.NewReader(file, cfg)
+	if err != nil {
+		return err
+	}
+
+	objects, err := ReadArchive(archiveReader)
+	if err != nil {
+		return fmt.Errorf("error reading backup archive: %v", err)
+	}
+	log.Infof("Found %d objects in backup archive.", len(objects))
+
+	if err := decryptFields(objects, cfg); err != nil {
+		return err
+	}
+
+	objects = filterObjects(objects, restoreFilter)
+	log.Infof("Selected %d objects for restore.", len(objects))
+
+	objects = remapNamespaces(objects, namespaceMappings)
+
+	report := newReport(cfg.RestoreFile, cfg.RestoreConflictPolicy, cfg.RestoreDryRun)
+	objects = skipRedacted(objects, report)
+
+	log.Infoln("Discovering resources served by the cluster...")
+	resources, err := discoverResources(clientset)
+	if err != nil {
+		return err
+	}
+
+	r := &restorer{
+		clientset:     clientset,
+		dynamicClient: dynamicClient,
+		resources:     resources,
+		uids:          make(map[string]types.UID),
+		policy:        cfg.RestoreConflictPolicy,
+		dryRun:        cfg.RestoreDryRun,
+		report:        report,
+
+		dryRunNamespaces: make(map[string]bool),
+	}
+	if r.dryRun {
+		log.Infoln("Dry run is enabled. Objects will be validated by the API server but not persisted.")
+	}
+	defer func() {
+		log.Infof("Restore summary: %v", r.report.Summary)
+		if r.dryRun {
+			if err := r.report.PrintPreview(os.Stdout); err != nil {
+				log.Errorf("Failed to print restore preview: %v", err)
+			}
+		}
+		if cfg.RestoreReport == "" {
+			return
+		}
+		if err := r.report.Write(cfg.RestoreReport); err != nil {
+			log.Errorf("Failed to write restore report: %v", err)
+			return
+		}
+		log.Infof("Restore report written to %s", cfg.RestoreReport)
+	}()
+
+	var deferred []ArchivedObject
+	for phase, phaseObjects := range orderObjects(objects) {
+		if len(phaseObjects) == 0 {
+			continue
+		}
+		log.Infof("Restoring phase %d (%d objects)...", phase, len(phaseObjects))
+		phaseDeferred, err := r.restoreObjects(ctx, phaseObjects)
+		if err != nil {
+			return err
+		}
+		deferred = append(deferred, phaseDeferred...)
+
+		if phase == phaseCRDs && !r.dryRun {
+			if err := r.establishCRDs(ctx, phaseObjects); err != nil {
+				return err
+			}
+		}
+	}
+
+	// A dry run does not create CRDs, so their custom resources cannot be validated
+	if r.dryRun {
+		deferred = r.skipArchivedCustomResources(objects, deferred)
+	}
+
+	// Retry objects whose resource types were not served when they were first reached
+	for pass := 1; len(deferred) > 0 && pass <= maxRetryPasses && !r.dryRun; pass++ {
+		log.Infof("Retrying %d objects with unknown resource types (pass %d)...", len(deferred), pass)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("restore aborted: %v", ctx.Err())
+		case <-time.After(retryDelay):
+		}
+		if err := r.refreshResources(); err != nil {
+			return err
+		}
+		remaining, err := r.restoreObjects(ctx, deferred)
+		if err != nil {
+			return err
+		}
+		if len(remaining) == len(deferred) {
+			break
+		}
+		deferred = remaining
+	}
+
+	for _, object := range deferred {
+		err := fmt.Errorf("resource type is not served by the cluster")
+		log.Errorf("Error restoring object '%s': %v", object.Path, err)
+		r.report.add(object, object.Resource, ActionFailed, err)
+	}
+
+	if failed := r.report.Failed(); failed > 0 {
+		return fmt.Errorf("%d of %d objects failed to restore", failed, len(objects))
+	}
+
+	log.Infof("Restore process completed successfully.")
+	return nil
+}
+
+// restoreObjects restores objects in order and returns those whose resource type is not yet served by the cluster.
+// It returns an error only when ctx is canceled or the conflict policy requires the restore to be aborted.
+func (r *restorer) restoreObjects(ctx context.Context, objects []ArchivedObject) ([]ArchivedObject, error) {
+	var deferred []ArchivedObject
+	for _, object := range objects {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("restore aborted: %v", err)
+		}
+		gvr, err := resourceFor(object)
+		if err != nil {
+			log.Errorf("Error restoring object '%s': %v", object.Path, err)
+			r.report.add(object, object.Resource, ActionFailed, err)
+			continue
+		}
+
+		if !r.resources[gvr] {
+			log.Debugf("Resource %s is not served yet, deferring object '%s'", gvr.String(), object.Path)
+			deferred = append(deferred, object)
+			continue
+		}
+
+		if r.dryRun {
+			action, changes, err := r.dryRunObject(ctx, gvr, object)
+			if errors.Is(err, errNamespaceNotCreated) {
+				r.report.addReason(object, gvr.String(), action, err.Error())
+				continue
+			}
+			r.report.addChanges(object, gvr.String(), action, changes, err)
+			if err != nil {
+				log.Errorf("Dry run of object '%s' failed: %v", object.Path, err)
+			}
+			continue
+		}
+
+		action, err := r.restoreObject(ctx, gvr, object)
+		r.report.add(object, gvr.String(), action, err)
+		if err != nil {
+			log.Errorf("Error restoring object '%s': %v", object.Path, err)
+			if errors.Is(err, errConflict) {
+				return nil, fmt.Errorf("restore aborted: %v", err)
+			}
+		}
+	}
+	return deferred, nil
+}
+
+// skipArchivedCustomResources records deferred objects whose resource type is defined by a CRD in the archive
+// as unvalidated, since the CRD is not installed by a dry run, and returns the remaining deferred objects.
+func (r *restorer) skipArchivedCustomResources(objects, deferred []ArchivedObject) []ArchivedObject {
+	defined := make(map[string]bool)
+	for _, object := range objects {
+		if object.Object.GetKind() != "CustomResourceDefinition" {
+			continue
+		}
+		group, _, _ := unstructured.NestedString(object.Object.Object, "spec", "group")
+		plural, _, _ := unstructured.NestedString(object.Object.Object, "spec", "names", "plural")
+		defined[plural+"."+group] = true
+	}
+
+	var remaining []ArchivedObject
+	for _, object := range deferred {
+		gvr, err := resourceFor(object)
+		if err == nil && defined[gvr.GroupResource().String()] {
+			log.Infof("Resource %s is defined by an archived CRD, skipping validation of '%s'", gvr.GroupResource().String(), object.Path)
+			r.report.addReason(object, gvr.String(), ActionUnvalidated, "CRD not yet installed")
+			continue
+		}
+		remaining = append(remaining, object)
+	}
+	return remaining
+}
+
+// establishCRDs waits for the restored CRDs to be established and refreshes the served resources.
+func (r *restorer) establishCRDs(ctx context.Context, objects []ArchivedObject) error {
+	for _, object := range objects {
+		if err := waitForCRDEstablished(ctx, r.dynamicClient, object.Object.GetName()); err != nil {
+			log.Errorf("Error waiting for CRD: %v", err)
+			r.report.fail(object, object.Resource, err)
+		}
+	}
+	return r.refreshResources()
+}
+
+func (r *restorer) refreshResources() error {
+	resources, err := discoverResources(r.clientset)
+	if err != nil {
+		return err
+	}
+	r.resources = resources
+	return nil
+}
+
+// decryptFields decrypts the fields encrypted by field-level encryption in every archived object.
+func decryptFields(objects []ArchivedObject, cfg *config.AppConfig) error {
+	fieldEncrypter, err := encryption.NewFieldEncrypter(cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		if !encryption.HasEncryptedFields(object.Object) {
+			continue
+		}
+		if fieldEncrypter == nil {
+			return fmt.Errorf("object '%s' has encrypted fields but no field encryption key is configured", object.Path)
+		}
+		if err := fieldEncrypter.DecryptObject(object.Object); err != nil {
+			return fmt.Errorf("error decrypting object '%s': %v", object.Path, err)
+		}
+	}
+	return nil
+}
+
+// skipRedacted drops objects whose values were replaced with redaction placeholders, since restoring them
+// would overwrite real values in the cluster, and records each of them as skipped in the report.
+func skipRedacted(objects []ArchivedObject, report *Report) []ArchivedObject {
+	restorable := make([]ArchivedObject, 0, len(objects))
+	for _, object := range objects {
+		if redact.IsRedacted(object.Object) {
+			log.Warnf("Skipping object '%s': it was redacted and cannot be restored", object.Path)
+			report.addReason(object, object.Resource, ActionSkipped, "object was redacted")
+			continue
+		}
+		restorable = append(restorable, object)
+	}
+	return restorable
+}
+
+// ReadArchive reads a decrypted kubebackup tarball and returns the objects stored in its
+// cluster-scoped/ and namespace-scoped/ trees.
+func ReadArchive(archive io.Reader) ([]ArchivedObject, error) {
+	decompressed, err := compression.NewReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer decompressed.Close()
+
+	tarReader := tar.NewReader(decompressed)
+
+	var objects []ArchivedObject
+	seen := make(map[string]bool)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading tar entry: %v", err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		namespace, resource, ok := manifest.ParsePath(header.Name)
+		if !ok {
+			log.Debugf("Skipping archive entry %s", header.Name)
+			continue
+		}
+
+		// Archives written with OUTPUT_FORMAT=both hold a .yaml and a .json copy of each object
+		objectPath := strings.TrimSuffix(path.Clean(header.Name), path.Ext(header.Name))
+		if seen[objectPath] {
+			continue
+		}
+		seen[objectPath] = true
+
+		data, err := io.ReadAll(tarReader)
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive entry '%s': %v", header.Name, err)
+		}
+
+		object, err := decodeObject(data)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding archive entry '%s': %v", header.Name, err)
+		}
+
+		objects = append(objects, ArchivedObject{
+			Path:      header.Name,
+			Namespace: namespace,
+			Resource:  resource,
+			Object:    object,
+		})
+	}
+
+	return objects, nil
+}
+
+// decodeObject converts YAML or JSON bytes into an unstructured object.
+func decodeObject(data []byte) (*unstructured.Unstructured, error) {
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("error converting object to JSON: %v", err)
+	}
+
+	object := &unstructured.Unstructured{}
+	if err := object.UnmarshalJSON(jsonData); err != nil {
+		return nil, fmt.Errorf("error unmarshalling object: %v", err)
+	}
+	return object, nil
+}
+
+// resourceFor returns the GroupVersionResource for an archived object based on its apiVersion and archive directory.
+func resourceFor(object ArchivedObject) (schema.GroupVersionResource, error) {
+	gv, err := schema.ParseGroupVersion(object.Object.GetAPIVersion())
+	if err != nil {
+		return schema.GroupVersionResource{}, fmt.Errorf("error parsing apiVersion '%s': %v", object.Object.GetAPIVersion(), err)
+	}
+	return gv.WithResource(object.Resource), nil
+}
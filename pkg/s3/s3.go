@@ -1,20 +1,24 @@
 package s3
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
-	"path/filepath"
-	"time"
+	"path"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/mattmattox/kubebackup/pkg/config"
 	"github.com/mattmattox/kubebackup/pkg/logging"
+	"github.com/mattmattox/kubebackup/pkg/storage"
 )
 
 var log = logging.SetupLogging()
@@ -68,90 +72,122 @@ func loadCustomCACerts(caPath string) (*x509.CertPool, error) {
 	return caCertPool, nil
 }
 
-func uploadToS3(sess *session.Session, bucket, key, filename, s3Folder string) error {
-	log.Infof("Uploading file: %s", filename)
-	// Open the file for reading
-	file, err := os.Open(filename)
+// Target stores backups in an S3 bucket.
+type Target struct {
+	name   string
+	bucket string
+	folder string
+	sess   *session.Session
+}
+
+// NewTarget creates an S3 target from cfg.
+func NewTarget(cfg config.TargetConfig) (*Target, error) {
+	if cfg.S3Bucket == "" {
+		return nil, fmt.Errorf("S3 target %s is missing a bucket", cfg.Name)
+	}
+
+	// Create S3 session
+	sess, err := createS3Session(cfg.S3Region, cfg.S3AccessKeyID, cfg.S3SecretAccessKey, cfg.S3Endpoint, cfg.S3CustomCAPath, cfg.S3DisableSSL)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("error creating S3 session: %v", err)
 	}
-	defer file.Close()
 
-	uploader := s3manager.NewUploader(sess)
+	return &Target{
+		name:   cfg.Name,
+		bucket: cfg.S3Bucket,
+		folder: strings.Trim(cfg.S3Folder, "/"),
+		sess:   sess,
+	}, nil
+}
 
-	s3Key := fmt.Sprintf("%s/%s", s3Folder, key)
+// Name returns the name of the target.
+func (t *Target) Name() string {
+	return t.name
+}
 
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(s3Key),
-		Body:   file,
+// objectKey returns the full S3 key for a key relative to the target folder.
+func (t *Target) objectKey(key string) string {
+	if t.folder == "" {
+		return key
+	}
+	return path.Join(t.folder, key)
+}
+
+// Upload streams body to the bucket under the target folder using a multipart upload. Each part is sent with a
+// SHA-256 checksum that S3 verifies and stores with the object.
+func (t *Target) Upload(ctx context.Context, key string, body io.Reader) error {
+	s3Key := t.objectKey(key)
+	log.Infof("Uploading file: %s", s3Key)
+
+	uploader := s3manager.NewUploader(t.sess)
+	_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:            aws.String(t.bucket),
+		Key:               aws.String(s3Key),
+		Body:              body,
+		ChecksumAlgorithm: aws.String(s3.ChecksumAlgorithmSha256),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to upload file, %v", err)
 	}
 
+	log.Infof("File successfully uploaded to S3: %s/%s", t.bucket, s3Key)
 	return nil
 }
 
-func cleanupOldBackups(sess *session.Session, s3Bucket, s3Folder string, retentionPeriod int) error {
+// List returns the objects stored under the target folder, with keys relative to the folder.
+func (t *Target) List(ctx context.Context) ([]storage.Object, error) {
 	log.Infoln("Retrieving list of objects in S3 bucket...")
 
-	log.Infoln("Retaining backups for", retentionPeriod, "days")
-
-	svc := s3.New(sess)
-	listObjectsInput := &s3.ListObjectsV2Input{
-		Bucket: aws.String(s3Bucket),
-		Prefix: aws.String(fmt.Sprintf("%s/kubebackup-", s3Folder)),
+	svc := s3.New(t.sess)
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(t.bucket),
 	}
-
-	objects, err := svc.ListObjectsV2(listObjectsInput)
-	if err != nil {
-		return fmt.Errorf("error listing objects in S3 bucket: %v", err)
+	prefix := ""
+	if t.folder != "" {
+		prefix = t.folder + "/"
+		input.Prefix = aws.String(prefix)
 	}
 
-	threshold := time.Now().AddDate(0, 0, -retentionPeriod)
-	for _, obj := range objects.Contents {
-		if obj.LastModified.Before(threshold) {
-			log.Infof("Deleting object %s from S3 bucket", *obj.Key)
-			deleteObjectInput := &s3.DeleteObjectInput{
-				Bucket: aws.String(s3Bucket),
-				Key:    obj.Key,
-			}
-
-			_, err := svc.DeleteObject(deleteObjectInput)
-			if err != nil {
-				return fmt.Errorf("error deleting object from S3 bucket: %v", err)
-			}
+	var objects []storage.Object
+	err := svc.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			objects = append(objects, storage.Object{
+				Key:          strings.TrimPrefix(aws.StringValue(obj.Key), prefix),
+				Size:         aws.Int64Value(obj.Size),
+				LastModified: aws.TimeValue(obj.LastModified),
+			})
 		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing objects in S3 bucket: %v", err)
 	}
 
-	return nil
+	return objects, nil
 }
 
-func UploadToS3(tarFilePath, s3Bucket, s3Folder, s3Region, s3AccessKey, s3SecretKey, s3Endpoint, caPath string, disableSSL bool, retentionPeriod int) error {
-	log.Infoln("Uploading tarball to S3...")
-
-	// Create S3 session
-	sess, err := createS3Session(s3Region, s3AccessKey, s3SecretKey, s3Endpoint, caPath, disableSSL)
+// Download returns the body of the object stored under key.
+func (t *Target) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	svc := s3.New(t.sess)
+	output, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(t.objectKey(key)),
+	})
 	if err != nil {
-		return fmt.Errorf("error creating S3 session: %v", err)
-	}
-
-	// Upload tarball
-	timestamp := filepath.Base(tarFilePath) // Use the tarball name as the key
-	s3Key := fmt.Sprintf("%s/%s", s3Folder, timestamp)
-	if err := uploadToS3(sess, s3Bucket, s3Key, tarFilePath, s3Folder); err != nil {
-		return fmt.Errorf("failed to upload tarball to S3: %v", err)
+		return nil, fmt.Errorf("error downloading object from S3 bucket: %v", err)
 	}
+	return output.Body, nil
+}
 
-	// Cleanup old backups
-	if retentionPeriod > 0 {
-		log.Infof("Cleaning up old backups older than %d days...", retentionPeriod)
-		if err := cleanupOldBackups(sess, s3Bucket, s3Folder, retentionPeriod); err != nil {
-			return fmt.Errorf("error cleaning up old backups: %v", err)
-		}
+// Delete removes the object stored under key.
+func (t *Target) Delete(ctx context.Context, key string) error {
+	svc := s3.New(t.sess)
+	_, err := svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(t.objectKey(key)),
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting object from S3 bucket: %v", err)
 	}
-
-	log.Infof("Backup successfully uploaded to S3: %s/%s", s3Bucket, s3Key)
 	return nil
 }
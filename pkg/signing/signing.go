@@ -0,0 +1,120 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mattmattox/kubebackup/pkg/config"
+	"github.com/mattmattox/kubebackup/pkg/manifest"
+)
+
+// Extension is appended to an archive key for its detached signature.
+const Extension = ".sig"
+
+// Validate ensures the configured signing and verification keys can be loaded.
+func Validate(cfg *config.AppConfig) error {
+	if cfg.SigningKeyFile != "" {
+		if _, err := LoadPrivateKey(cfg.SigningKeyFile); err != nil {
+			return err
+		}
+	}
+	if cfg.SigningPublicKeyFile != "" {
+		if _, err := LoadPublicKey(cfg.SigningPublicKeyFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadPrivateKey reads a PEM-encoded PKCS #8 ed25519 private key, as written by
+// `openssl genpkey -algorithm ed25519`.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signing key: %v", err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key in %s is not an ed25519 key", path)
+	}
+	return privateKey, nil
+}
+
+// LoadPublicKey reads a PEM-encoded PKIX ed25519 public key, as written by `openssl pkey -pubout`.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing verification key: %v", err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("verification key in %s is not an ed25519 key", path)
+	}
+	return publicKey, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	return block, nil
+}
+
+// Sign returns the base64-encoded signature of an archive, computed over its hex-encoded SHA-256 checksum.
+func Sign(key ed25519.PrivateKey, checksum string) []byte {
+	signature := ed25519.Sign(key, []byte(checksum))
+	return []byte(base64.StdEncoding.EncodeToString(signature) + "\n")
+}
+
+// Verify checks a base64-encoded signature produced by Sign against an archive checksum.
+func Verify(key ed25519.PublicKey, checksum string, signature []byte) error {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("malformed signature: %v", err)
+	}
+	if !ed25519.Verify(key, []byte(checksum), decoded) {
+		return fmt.Errorf("signature does not match archive")
+	}
+	return nil
+}
+
+// VerifyFile checks the detached signature of an archive file with the public key in publicKeyPath.
+// An archive without a signature file is rejected.
+func VerifyFile(archivePath, signaturePath, publicKeyPath string) error {
+	key, err := LoadPublicKey(publicKeyPath)
+	if err != nil {
+		return err
+	}
+
+	signature, err := os.ReadFile(signaturePath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("archive is not signed: %s not found", signaturePath)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading signature: %v", err)
+	}
+
+	checksum, err := manifest.FileChecksum(archivePath)
+	if err != nil {
+		return err
+	}
+	return Verify(key, checksum, signature)
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mattmattox/kubebackup/pkg/logging"
+	"github.com/mattmattox/kubebackup/pkg/manifest"
+	"github.com/mattmattox/kubebackup/pkg/signing"
+)
+
+var log = logging.SetupLogging()
+
+// BackupPrefix is the name prefix shared by every kubebackup archive.
+const BackupPrefix = "kubebackup_"
+
+// Object describes a file stored in a target.
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Target is a location backups are stored in, such as an S3 bucket or a local directory.
+type Target interface {
+	// Name identifies the target in logs, metrics and status.
+	Name() string
+	// Upload stores the contents of body under key.
+	Upload(ctx context.Context, key string, body io.Reader) error
+	// List returns the objects stored in the target.
+	List(ctx context.Context) ([]Object, error)
+	// Download returns a reader for the object stored under key.
+	Download(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key.
+	Delete(ctx context.Context, key string) error
+}
+
+// Archive is a backup archive together with the sidecars uploaded next to it.
+type Archive struct {
+	Key      string
+	Checksum string
+	// Signature is the detached signature of the archive, if it was signed
+	Signature []byte
+}
+
+// UploadResult records the outcome of uploading a backup to a single target.
+type UploadResult struct {
+	Target         string `json:"target"`
+	Key            string `json:"key"`
+	Success        bool   `json:"success"`
+	Checksum       string `json:"checksum,omitempty"`
+	Error          string `json:"error,omitempty"`
+	RetentionError string `json:"retentionError,omitempty"`
+}
+
+// uploadSidecars uploads the checksum and signature of an archive that was stored in the target.
+func uploadSidecars(ctx context.Context, target Target, archive Archive) error {
+	checksumKey := archive.Key + manifest.ChecksumExtension
+	if err := target.Upload(ctx, checksumKey, strings.NewReader(manifest.ChecksumFile(archive.Checksum, path.Base(archive.Key)))); err != nil {
+		return fmt.Errorf("error uploading checksum: %v", err)
+	}
+
+	if archive.Signature != nil {
+		if err := target.Upload(ctx, archive.Key+signing.Extension, bytes.NewReader(archive.Signature)); err != nil {
+			return fmt.Errorf("error uploading signature: %v", err)
+		}
+	}
+	return nil
+}
+
+// ApplyRetention deletes backups in the target that are older than retentionPeriod days.
+func ApplyRetention(ctx context.Context, target Target, retentionPeriod int) error {
+	log.Infoln("Retaining backups for", retentionPeriod, "days")
+
+	objects, err := target.List(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing backups: %v", err)
+	}
+
+	threshold := time.Now().AddDate(0, 0, -retentionPeriod)
+	for _, object := range objects {
+		if !strings.HasPrefix(path.Base(object.Key), BackupPrefix) {
+			continue
+		}
+		if object.LastModified.Before(threshold) {
+			log.Infof("Deleting backup %s from target %s", object.Key, target.Name())
+			if err := target.Delete(ctx, object.Key); err != nil {
+				return fmt.Errorf("error deleting backup %s: %v", object.Key, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Stream uploads everything written to it to several targets at once. Each target reads from its own pipe,
+// so the archive is never stored locally. A target that fails is dropped and the remaining targets continue.
+type Stream struct {
+	key     string
+	uploads []*streamUpload
+}
+
+type streamUpload struct {
+	target Target
+	writer *io.PipeWriter
+	done   chan struct{}
+	err    error
+}
+
+// NewStream starts an upload of key to every target and returns the stream feeding them. Canceling ctx aborts the
+// uploads.
+func NewStream(ctx context.Context, targets []Target, key string) *Stream {
+	s := &Stream{key: key}
+	for _, target := range targets {
+		reader, writer := io.Pipe()
+		upload := &streamUpload{target: target, writer: writer, done: make(chan struct{})}
+		s.uploads = append(s.uploads, upload)
+
+		log.Infof("Streaming %s to target %s...", key, target.Name())
+		go func() {
+			defer close(upload.done)
+			err := upload.target.Upload(ctx, key, reader)
+			if err == nil {
+				err = io.ErrClosedPipe
+			} else {
+				upload.err = err
+			}
+			// Unblock writes if the upload stopped reading early
+			reader.CloseWithError(err)
+		}()
+	}
+	return s
+}
+
+// Write sends p to every target that has not failed. It returns an error only when every target has failed.
+func (s *Stream) Write(p []byte) (int, error) {
+	alive := 0
+	var lastErr error
+	for _, upload := range s.uploads {
+		if upload.failed() {
+			continue
+		}
+		if _, err := upload.writer.Write(p); err != nil {
+			<-upload.done
+			if upload.err == nil {
+				upload.err = err
+			}
+			log.Errorf("Error streaming backup to target %s: %v", upload.target.Name(), upload.err)
+			lastErr = upload.err
+			continue
+		}
+		alive++
+	}
+	if alive == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no targets left to upload to")
+		}
+		return 0, fmt.Errorf("error uploading backup: all targets failed: %v", lastErr)
+	}
+	return len(p), nil
+}
+
+// failed reports whether the upload has already stopped with an error.
+func (u *streamUpload) failed() bool {
+	select {
+	case <-u.done:
+		return u.err != nil
+	default:
+		return false
+	}
+}
+
+// Abort stops every upload so no partial archive is stored, and waits for the uploads to return.
+func (s *Stream) Abort(err error) {
+	for _, upload := range s.uploads {
+		upload.writer.CloseWithError(err)
+	}
+	for _, upload := range s.uploads {
+		<-upload.done
+	}
+}
+
+// Finish completes the upload to every target, uploads the checksum and signature sidecars of the archive and
+// applies retention to each target that succeeded.
+func (s *Stream) Finish(ctx context.Context, archive Archive, retentionPeriod int) []UploadResult {
+	for _, upload := range s.uploads {
+		upload.writer.Close()
+	}
+
+	var wg sync.WaitGroup
+	results := make([]UploadResult, len(s.uploads))
+	for i, upload := range s.uploads {
+		wg.Add(1)
+		go func(i int, upload *streamUpload) {
+			defer wg.Done()
+			<-upload.done
+			results[i] = finishUpload(ctx, upload.target, archive, upload.err, retentionPeriod)
+		}(i, upload)
+	}
+	wg.Wait()
+	return results
+}
+
+func finishUpload(ctx context.Context, target Target, archive Archive, err error, retentionPeriod int) UploadResult {
+	result := UploadResult{Target: target.Name(), Key: archive.Key, Success: true, Checksum: archive.Checksum}
+	if err == nil {
+		err = uploadSidecars(ctx, target, archive)
+	}
+	if err != nil {
+		log.Errorf("Error uploading backup to target %s: %v", target.Name(), err)
+		result.Success = false
+		result.Error = err.Error()
+		return result
+	}
+
+	log.Infof("Backup successfully uploaded to target %s: %s", target.Name(), archive.Key)
+	if retentionPeriod > 0 {
+		log.Infof("Cleaning up backups older than %d days in target %s...", retentionPeriod, target.Name())
+		if err := ApplyRetention(ctx, target, retentionPeriod); err != nil {
+			log.Errorf("Error cleaning up old backups in target %s: %v", target.Name(), err)
+			result.RetentionError = err.Error()
+		}
+	}
+	return result
+}
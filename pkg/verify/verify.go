@@ -0,0 +1,284 @@
+package verify
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattmattox/kubebackup/pkg/compression"
+	"github.com/mattmattox/kubebackup/pkg/config"
+	"github.com/mattmattox/kubebackup/pkg/encryption"
+	"github.com/mattmattox/kubebackup/pkg/logging"
+	"github.com/mattmattox/kubebackup/pkg/manifest"
+	"github.com/mattmattox/kubebackup/pkg/signing"
+	"github.com/mattmattox/kubebackup/pkg/storage"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+var log = logging.SetupLogging()
+
+// Result is the outcome of verifying a stored backup.
+type Result struct {
+	Target           string             `json:"target"`
+	Archive          string             `json:"archive"`
+	Valid            bool               `json:"valid"`
+	Checksum         string             `json:"checksum,omitempty"`
+	ExpectedChecksum string             `json:"expectedChecksum,omitempty"`
+	Contents         string             `json:"contents"`
+	Objects          int                `json:"objects"`
+	Partial          bool               `json:"partial,omitempty"`
+	Failures         []manifest.Failure `json:"failures,omitempty"`
+	Missing          []string           `json:"missing,omitempty"`
+	Unexpected       []string           `json:"unexpected,omitempty"`
+	Mismatched       []string           `json:"mismatched,omitempty"`
+	Invalid          []string           `json:"invalid,omitempty"`
+	Errors           []string           `json:"errors,omitempty"`
+	Time             string             `json:"time"`
+}
+
+func (r *Result) addError(format string, args ...interface{}) {
+	err := fmt.Sprintf(format, args...)
+	log.Errorf("Verification of %s in target %s: %s", r.Archive, r.Target, err)
+	r.Errors = append(r.Errors, err)
+}
+
+// Latest returns the key of the most recent backup archive stored in a target.
+func Latest(ctx context.Context, target storage.Target) (string, error) {
+	objects, err := target.List(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error listing backups: %v", err)
+	}
+
+	var latest *storage.Object
+	for i, object := range objects {
+		name := path.Base(object.Key)
+		if !strings.HasPrefix(name, storage.BackupPrefix) || strings.HasSuffix(name, manifest.ChecksumExtension) || strings.HasSuffix(name, signing.Extension) {
+			continue
+		}
+		if latest == nil || object.LastModified.After(latest.LastModified) {
+			latest = &objects[i]
+		}
+	}
+	if latest == nil {
+		return "", fmt.Errorf("no backups found in target %s", target.Name())
+	}
+	return latest.Key, nil
+}
+
+// Contents values of a Result.
+const (
+	ContentsChecked    = "checked"
+	ContentsNotChecked = "not checked"
+)
+
+// Verify downloads a backup from a target and checks its checksum, signature and manifest, and that every
+// object file parses as a Kubernetes object. An empty key verifies the most recent backup. The contents of an
+// encrypted archive are only checked when an identity or passphrase is configured; otherwise the archive is
+// verified by its checksum and signature alone.
+func Verify(ctx context.Context, target storage.Target, key string, cfg *config.AppConfig) *Result {
+	result := &Result{Target: target.Name(), Archive: key, Time: time.Now().Format(time.RFC3339)}
+	if key == "" {
+		latest, err := Latest(ctx, target)
+		if err != nil {
+			result.addError("%v", err)
+			return result
+		}
+		key = latest
+		result.Archive = key
+	}
+	log.Infof("Verifying backup %s in target %s...", key, target.Name())
+
+	expected, err := readChecksum(ctx, target, key)
+	if err != nil {
+		result.addError("%v", err)
+	}
+	result.ExpectedChecksum = expected
+
+	body, err := target.Download(ctx, key)
+	if err != nil {
+		result.addError("error downloading archive: %v", err)
+		return result
+	}
+	defer body.Close()
+
+	// Hash everything read from the archive, including anything after the end of the tar stream
+	hash := sha256.New()
+	archiveReader := io.TeeReader(body, hash)
+	verifyContents(archiveReader, cfg, result)
+	if _, err := io.Copy(io.Discard, archiveReader); err != nil {
+		result.addError("error reading archive: %v", err)
+	}
+	result.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	if expected != "" && result.Checksum != expected {
+		result.addError("checksum mismatch: expected %s, got %s", expected, result.Checksum)
+	}
+
+	if cfg.SigningPublicKeyFile != "" {
+		if err := verifySignature(ctx, target, key, result.Checksum, cfg.SigningPublicKeyFile); err != nil {
+			result.addError("%v", err)
+		}
+	}
+
+	result.Valid = len(result.Errors) == 0 && len(result.Missing) == 0 && len(result.Unexpected) == 0 &&
+		len(result.Mismatched) == 0 && len(result.Invalid) == 0
+	switch {
+	case result.Valid && result.Contents == ContentsNotChecked:
+		log.Infof("Backup %s in target %s matches its checksum and signature; contents not checked.", key, target.Name())
+	case result.Valid && result.Partial:
+		log.Warnf("Backup %s in target %s is valid but partial (%d objects, %d failures).", key, target.Name(), result.Objects, len(result.Failures))
+	case result.Valid:
+		log.Infof("Backup %s in target %s is valid (%d objects).", key, target.Name(), result.Objects)
+	default:
+		log.Errorf("Backup %s in target %s failed verification.", key, target.Name())
+	}
+	return result
+}
+
+// verifyContents reads the archive and compares its object files against its manifest.
+func verifyContents(archive io.Reader, cfg *config.AppConfig, result *Result) {
+	decrypted, err := encryption.NewReader(archive, cfg)
+	if errors.Is(err, encryption.ErrNoIdentity) {
+		result.Contents = ContentsNotChecked
+		return
+	}
+	if err != nil {
+		result.addError("%v", err)
+		return
+	}
+	result.Contents = ContentsChecked
+	decompressed, err := compression.NewReader(decrypted)
+	if err != nil {
+		result.addError("%v", err)
+		return
+	}
+	defer decompressed.Close()
+
+	var archiveManifest *manifest.Manifest
+	checksums := make(map[string]string)
+	tarReader := tar.NewReader(decompressed)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			result.addError("error reading tar entry: %v", err)
+			return
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := strings.TrimPrefix(path.Clean(header.Name), "./")
+		data, err := io.ReadAll(tarReader)
+		if err != nil {
+			result.addError("error reading archive entry '%s': %v", name, err)
+			return
+		}
+
+		if name == manifest.FileName {
+			archiveManifest, err = manifest.Read(bytes.NewReader(data))
+			if err != nil {
+				result.addError("%v", err)
+			}
+			continue
+		}
+		if _, _, ok := manifest.ParsePath(name); !ok {
+			continue
+		}
+
+		sum := sha256.Sum256(data)
+		checksums[name] = hex.EncodeToString(sum[:])
+		if err := validateObject(data); err != nil {
+			result.Invalid = append(result.Invalid, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	result.Objects = len(checksums)
+
+	if archiveManifest == nil {
+		result.addError("archive has no %s", manifest.FileName)
+		return
+	}
+	result.Partial = archiveManifest.Partial
+	result.Failures = archiveManifest.Failures
+
+	listed := make(map[string]bool, len(archiveManifest.Objects))
+	for _, entry := range archiveManifest.Objects {
+		listed[entry.Path] = true
+		checksum, ok := checksums[entry.Path]
+		switch {
+		case !ok:
+			result.Missing = append(result.Missing, entry.Path)
+		case checksum != entry.SHA256:
+			result.Mismatched = append(result.Mismatched, entry.Path)
+		}
+	}
+	for name := range checksums {
+		if !listed[name] {
+			result.Unexpected = append(result.Unexpected, name)
+		}
+	}
+	sort.Strings(result.Unexpected)
+}
+
+// validateObject checks that data decodes to a Kubernetes object with an apiVersion, kind and name.
+func validateObject(data []byte) error {
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return fmt.Errorf("error converting object to JSON: %v", err)
+	}
+	object := &unstructured.Unstructured{}
+	if err := object.UnmarshalJSON(jsonData); err != nil {
+		return fmt.Errorf("error unmarshalling object: %v", err)
+	}
+	if object.GetAPIVersion() == "" || object.GetKind() == "" || object.GetName() == "" {
+		return fmt.Errorf("object is missing apiVersion, kind or metadata.name")
+	}
+	return nil
+}
+
+// readChecksum downloads the checksum sidecar of an archive.
+func readChecksum(ctx context.Context, target storage.Target, key string) (string, error) {
+	data, err := download(ctx, target, key+manifest.ChecksumExtension)
+	if err != nil {
+		return "", fmt.Errorf("error downloading checksum: %v", err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("checksum file is empty")
+	}
+	return fields[0], nil
+}
+
+// verifySignature downloads the signature sidecar of an archive and checks it against the archive checksum.
+func verifySignature(ctx context.Context, target storage.Target, key, checksum, publicKeyPath string) error {
+	publicKey, err := signing.LoadPublicKey(publicKeyPath)
+	if err != nil {
+		return err
+	}
+	signature, err := download(ctx, target, key+signing.Extension)
+	if err != nil {
+		return fmt.Errorf("archive is not signed: %v", err)
+	}
+	return signing.Verify(publicKey, checksum, signature)
+}
+
+func download(ctx context.Context, target storage.Target, key string) ([]byte, error) {
+	body, err := target.Download(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}